@@ -0,0 +1,96 @@
+package esl
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// Channel represents an active FreeSWITCH channel.
+type Channel struct {
+	UUID      string    // channel unique ID
+	Direction string    // inbound or outbound
+	Name      string    // channel name
+	CIDName   string    // caller ID name
+	CIDNum    string    // caller ID number
+	Dest      string    // destination number
+	State     string    // channel state
+	CallState string    // call state
+	Created   time.Time // creation time
+}
+
+// ActiveChannels returns the list of the active channels.
+//
+// It sends the "api show channels as json" command through the active connection.
+// Returns ErrNotConnected if the monitor is not running.
+func (m *Monitor) ActiveChannels(ctx context.Context) ([]Channel, error) {
+	var result struct {
+		Rows []struct {
+			UUID         string `json:"uuid"`
+			Direction    string `json:"direction"`
+			Name         string `json:"name"`
+			CIDName      string `json:"cid_name"`
+			CIDNum       string `json:"cid_num"`
+			Dest         string `json:"dest"`
+			State        string `json:"state"`
+			CallState    string `json:"callstate"`
+			CreatedEpoch string `json:"created_epoch"`
+		} `json:"rows"`
+	}
+
+	if err := m.APIJSON(ctx, "show channels as json", &result); err != nil {
+		return nil, err
+	}
+
+	channels := make([]Channel, 0, len(result.Rows))
+
+	for _, row := range result.Rows {
+		var created time.Time
+		if epoch, err := strconv.ParseInt(row.CreatedEpoch, 10, 64); err == nil {
+			created = time.Unix(epoch, 0)
+		}
+
+		channels = append(channels, Channel{
+			UUID:      row.UUID,
+			Direction: row.Direction,
+			Name:      row.Name,
+			CIDName:   row.CIDName,
+			CIDNum:    row.CIDNum,
+			Dest:      row.Dest,
+			State:     row.State,
+			CallState: row.CallState,
+			Created:   created,
+		})
+	}
+
+	return channels, nil
+}
+
+// WithReconcileChannels sets the callback called with the active channels
+// after each successful connect, including reconnects.
+//
+// The events sent while the monitor was disconnected are lost, so a stateful
+// consumer can use the snapshot to add the channels it didn't know about and
+// remove the ones that are gone. The callback is called in a separate goroutine
+// with the context canceled on disconnect, and the events may be received
+// concurrently with it. A nil callback disables the reconcile.
+func (m *Monitor) WithReconcileChannels(fn func(ctx context.Context, channels []Channel)) *Monitor {
+	m.reconcile = fn
+
+	return m
+}
+
+// reconcileChannels requests the active channels and passes them to the reconcile callback.
+func (m *Monitor) reconcileChannels(ctx context.Context) {
+	channels, err := m.ActiveChannels(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			m.log.Warn("esl: reconcile channels", slog.String("error", err.Error()))
+		}
+
+		return
+	}
+
+	m.reconcile(ctx, channels)
+}
@@ -0,0 +1,124 @@
+package esl
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	esl "github.com/mdigger/eslmon/internal"
+)
+
+// Client is the lightweight ESL connection for the commands only,
+// without the event subscription and dispatch.
+//
+// It's intended for the tools running a few commands and exiting.
+// Use Monitor for the events. The commands are sent one by one,
+// so it's safe for concurrent use.
+type Client struct {
+	mu      sync.Mutex // to send the commands one by one
+	conn    *esl.Conn
+	netConn net.Conn
+}
+
+// Connect connects to the ESL server, authenticates and returns the Client.
+//
+// The address is the same as for New, including the Unix domain socket path.
+// The context limits the connection and authentication time.
+func Connect(ctx context.Context, addr, password string) (*Client, error) {
+	const cmdTimeout = time.Second * 5 // the same as for Monitor
+
+	network := "tcp"
+	if path, ok := unixSocketPath(addr); ok {
+		network, addr = "unix", path
+	} else {
+		var err error
+		if addr, err = normalizeAddr(addr); err != nil {
+			return nil, err
+		}
+	}
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("dialer: %w", err)
+	}
+
+	eslConn, _, err := esl.NewConn(ctx, conn, password, cmdTimeout)
+	if err != nil {
+		conn.Close()
+
+		return nil, fmt.Errorf("authenticate: %w", err)
+	}
+
+	return &Client{
+		mu:      sync.Mutex{},
+		conn:    eslConn,
+		netConn: conn,
+	}, nil
+}
+
+// API sends the "api" command and returns the response body.
+// Returns an error if the response starts with "-ERR".
+func (c *Client) API(ctx context.Context, cmd string) (string, error) {
+	resp, err := c.send(ctx, "api "+cmd)
+	if err != nil {
+		return "", fmt.Errorf("api %s: %w", cmd, err)
+	}
+
+	return resp.Body, nil
+}
+
+// Exec sends the commands one by one and stops on the first error.
+func (c *Client) Exec(ctx context.Context, cmds ...string) error {
+	for _, cmd := range cmds {
+		if cmd == "" {
+			continue // nothing to send
+		}
+
+		if _, err := c.send(ctx, cmd); err != nil {
+			return fmt.Errorf("exec: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Close sends the "exit" command and closes the connection.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_ = c.conn.Write("exit") // the server closes the connection anyway
+
+	if err := c.netConn.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+
+	return nil
+}
+
+// send sends the command and returns the reply.
+func (c *Client) send(ctx context.Context, cmd string) (Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resp, err := c.conn.SendCtx(ctx, cmd)
+	if err != nil {
+		return Response{}, err //nolint:wrapcheck
+	}
+
+	switch resp.ContentType {
+	case "command/reply", "api/response", "text/disconnect-notice":
+	default:
+		return Response{}, fmt.Errorf("%w: %q", ErrContentType, resp.ContentType)
+	}
+
+	if err := resp.AsErr(); err != nil {
+		return Response{}, err //nolint:wrapcheck
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,361 @@
+package esl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+
+	esl "github.com/mdigger/eslmon/internal"
+)
+
+// MyEvents subscribes to the events of the single channel with the given UUID.
+//
+// If the uuid is empty, the plain "myevents" form is sent, which is used
+// in the outbound socket mode.
+//
+// The myevents command replaces the event subscription of the connection,
+// so the subscribers of the monitor get only the events of the channel.
+// The next ApplySubscriptions, also called on the subscription changes,
+// sends the whole subscription of the monitor again.
+//
+// Returns ErrInvalidArgument if an argument contains a line break,
+// and ErrNotConnected if the monitor is not running.
+func (m *Monitor) MyEvents(ctx context.Context, uuid string) error {
+	if err := checkArgs(uuid); err != nil {
+		return fmt.Errorf("myevents: %w", err)
+	}
+
+	cmd := "myevents"
+	if uuid != "" {
+		cmd += " " + uuid
+	}
+
+	if err := m.replaceSubscription(ctx, cmd); err != nil {
+		return fmt.Errorf("myevents: %w", err)
+	}
+
+	return nil
+}
+
+// MyEventsWith subscribes to the events of the channel like MyEvents,
+// and to the extra events, like HEARTBEAT, in the same batch.
+//
+// The myevents command replaces the event subscription of the connection,
+// so the extra events are subscribed after it with the "event plain" command.
+// The names are classified as for Subscribe. If no extra events are given,
+// it's the same as MyEvents.
+//
+// Returns ErrInvalidArgument if an argument contains a line break,
+// and ErrNotConnected if the monitor is not running.
+func (m *Monitor) MyEventsWith(ctx context.Context, uuid string, extra ...string) error {
+	if len(extra) == 0 {
+		return m.MyEvents(ctx, uuid)
+	}
+
+	if err := checkArgs(append([]string{uuid}, extra...)...); err != nil {
+		return fmt.Errorf("myevents: %w", err)
+	}
+
+	cmd := "myevents"
+	if uuid != "" {
+		cmd += " " + uuid
+	}
+
+	plan := SubscriptionPlan{All: true} //nolint:exhaustruct
+	if events := eventNamesSet(extra); events != nil {
+		plan = newSubscriptionPlan(events)
+	}
+
+	if err := m.replaceSubscription(ctx, append([]string{cmd}, plan.commands(maxSubscribeLength)...)...); err != nil {
+		return fmt.Errorf("myevents: %w", err)
+	}
+
+	return nil
+}
+
+// replaceSubscription sends the commands replacing the server subscription
+// out of the subscription plan, so the whole plan is sent again on the next
+// ApplySubscriptions.
+func (m *Monitor) replaceSubscription(ctx context.Context, cmds ...string) error {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+
+	m.resubscribe = true // even on error, as some commands may be applied
+
+	_, err := m.commands(ctx, cmds...)
+
+	return err
+}
+
+// DivertEvents turns on or off the diverting of the channel events to the socket
+// while the dialplan application is executing. It's used in the outbound socket mode.
+//
+// Returns ErrNotConnected if the monitor is not running.
+func (m *Monitor) DivertEvents(ctx context.Context, on bool) error {
+	cmd := "divert_events off"
+	if on {
+		cmd = "divert_events on"
+	}
+
+	if _, err := m.command(ctx, cmd); err != nil {
+		return fmt.Errorf("divert events: %w", err)
+	}
+
+	return nil
+}
+
+// Hangup hangs up the channel with the given UUID and the hangup cause.
+// The cause is optional, FreeSWITCH uses NORMAL_CLEARING if it's empty.
+//
+// Returns ErrInvalidArgument if an argument contains a line break,
+// and ErrNotConnected if the monitor is not running.
+func (m *Monitor) Hangup(ctx context.Context, uuid, cause string) error {
+	if err := checkArgs(uuid, cause); err != nil {
+		return fmt.Errorf("hangup: %w", err)
+	}
+
+	cmd := "api uuid_kill " + uuid
+	if cause != "" {
+		cmd += " " + cause
+	}
+
+	if _, err := m.command(ctx, cmd); err != nil {
+		return fmt.Errorf("hangup: %w", err)
+	}
+
+	return nil
+}
+
+// Transfer transfers the channel with the given UUID to the destination extension.
+//
+// Returns ErrInvalidArgument if an argument contains a line break,
+// and ErrNotConnected if the monitor is not running.
+func (m *Monitor) Transfer(ctx context.Context, uuid, dest string) error {
+	if err := checkArgs(uuid, dest); err != nil {
+		return fmt.Errorf("transfer: %w", err)
+	}
+
+	if _, err := m.command(ctx, "api uuid_transfer "+uuid+" "+dest); err != nil {
+		return fmt.Errorf("transfer: %w", err)
+	}
+
+	return nil
+}
+
+// SetVar sets the variable of the channel with the given UUID.
+//
+// Returns ErrInvalidArgument if an argument contains a line break,
+// and ErrNotConnected if the monitor is not running.
+func (m *Monitor) SetVar(ctx context.Context, uuid, name, value string) error {
+	if err := checkArgs(uuid, name, value); err != nil {
+		return fmt.Errorf("set var: %w", err)
+	}
+
+	if _, err := m.command(ctx, "api uuid_setvar "+uuid+" "+name+" "+value); err != nil {
+		return fmt.Errorf("set var: %w", err)
+	}
+
+	return nil
+}
+
+// checkArgs returns ErrInvalidArgument if any argument contains a line break,
+// which ends the command and sends the rest as the next command.
+func checkArgs(args ...string) error {
+	for _, arg := range args {
+		if strings.ContainsAny(arg, "\r\n") {
+			return fmt.Errorf("%w: %q", ErrInvalidArgument, arg)
+		}
+	}
+
+	return nil
+}
+
+// APIJSON sends the API command and unmarshals the JSON response body into v.
+//
+// The command is sent as "api <cmd>", so it should request the JSON output
+// itself, e.g. "show channels as json".
+// The "-ERR" response is returned as an error without unmarshaling.
+//
+// Returns ErrNotConnected if the monitor is not running.
+func (m *Monitor) APIJSON(ctx context.Context, cmd string, v any) error {
+	resp, err := m.command(ctx, "api "+cmd)
+	if err != nil {
+		return fmt.Errorf("api %s: %w", cmd, err)
+	}
+
+	if err := json.Unmarshal(resp.BodyBytes(), v); err != nil {
+		return fmt.Errorf("api %s: %w", cmd, err)
+	}
+
+	return nil
+}
+
+// Exec sends the commands through the active connection at once and waits
+// for all replies.
+//
+// The commands are buffered and flushed together, which is useful to send
+// several "filter" or "event" commands in one batch.
+// Returns the first error reply.
+//
+// Returns ErrNotConnected if the monitor is not running.
+func (m *Monitor) Exec(ctx context.Context, cmds ...string) error {
+	if _, err := m.commands(ctx, cmds...); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+
+	return nil
+}
+
+// WithMaxConcurrentCommands limits the number of the concurrent commands
+// sent through the monitor connection. A non-positive n removes the limit.
+//
+// The commands over the limit wait for a free slot until the context is done.
+func (m *Monitor) WithMaxConcurrentCommands(n int) *Monitor {
+	m.cmdSem = nil
+	if n > 0 {
+		m.cmdSem = make(chan struct{}, n)
+	}
+
+	return m
+}
+
+// command sends the command through the active connection and waits for the reply.
+//
+// The reply is delivered by the Run read loop.
+// Returns ErrNotConnected if the monitor is not running.
+func (m *Monitor) command(ctx context.Context, cmd string) (esl.Response, error) {
+	resps, err := m.commands(ctx, cmd)
+	if err != nil {
+		return esl.Response{}, err
+	}
+
+	return resps[0], nil
+}
+
+// commands sends the commands through the active connection with a single flush
+// and waits for all replies.
+//
+// Stops on the first error reply and returns it with the previous replies.
+// Returns ErrNotConnected if the monitor is not running.
+func (m *Monitor) commands(ctx context.Context, cmds ...string) ([]esl.Response, error) {
+	if m.cmdSem != nil {
+		select {
+		case m.cmdSem <- struct{}{}:
+			defer func() { <-m.cmdSem }()
+		case <-ctx.Done():
+			return nil, context.Cause(ctx) //nolint:wrapcheck
+		}
+	}
+
+	m.mu.Lock()
+	conn := m.conn
+	m.mu.Unlock()
+
+	if conn == nil {
+		return nil, ErrNotConnected
+	}
+
+	replies := make([]chan esl.Response, 0, len(cmds))
+	for _, cmd := range cmds {
+		if cmd != "" { // nothing is sent, so no reply
+			replies = append(replies, make(chan esl.Response, 1))
+		}
+	}
+
+	// the waiters are queued under the connection write lock before the
+	// commands are sent, to keep the replies order
+	err := conn.WriteBatch(cmds, func() error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if m.conn != conn {
+			return ErrNotConnected // reconnected
+		}
+
+		m.pending = append(m.pending, replies...)
+
+		return nil
+	})
+	if err != nil {
+		m.dequeue(replies)
+
+		return nil, err //nolint:wrapcheck // wrapped with ErrWrite
+	}
+
+	if m.cmdTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, m.cmdTimeout, ErrTimeout)
+		defer cancel()
+	}
+
+	resps := make([]esl.Response, 0, len(replies))
+
+	for _, reply := range replies {
+		select {
+		case <-ctx.Done():
+			return resps, context.Cause(ctx) //nolint:wrapcheck
+		case resp, ok := <-reply:
+			if !ok {
+				return resps, ErrNotConnected // the connection is closed
+			}
+
+			resps = append(resps, resp)
+
+			if err := resp.AsErr(); err != nil {
+				return resps, err
+			}
+		}
+	}
+
+	return resps, nil
+}
+
+// reply delivers the command reply to the oldest waiting command.
+func (m *Monitor) reply(resp esl.Response) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.pending) == 0 {
+		// nobody waits for this reply: the command was not sent by the monitor
+		m.log.Debug("esl: unexpected reply", slog.Any("response", resp))
+
+		return
+	}
+
+	reply := m.pending[0]
+	m.pending = m.pending[1:]
+	reply <- resp // buffered
+}
+
+// dequeue removes the waiters of the commands failed to be sent.
+func (m *Monitor) dequeue(replies []chan esl.Response) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pending = slices.DeleteFunc(m.pending, func(reply chan esl.Response) bool {
+		return slices.Contains(replies, reply)
+	})
+}
+
+// setConn sets the active connection.
+//
+// When the connection is reset, all waiting commands are released.
+func (m *Monitor) setConn(conn *esl.Conn, netConn net.Conn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.conn = conn
+	m.netConn = netConn
+
+	if conn == nil {
+		for _, reply := range m.pending {
+			close(reply)
+		}
+
+		m.pending = nil
+	}
+}
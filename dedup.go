@@ -0,0 +1,61 @@
+package esl
+
+// sequenceDedup remembers the recent event sequences to detect the duplicates.
+//
+// Used only by the read loop.
+type sequenceDedup struct {
+	seen  map[int64]struct{} // the remembered sequences
+	order []int64            // ring of the remembered sequences to forget the oldest
+	next  int                // index for the next sequence in the ring
+	max   int64              // the maximum seen sequence
+}
+
+// newSequenceDedup returns a new dedup for the given window size.
+func newSequenceDedup(window int) *sequenceDedup {
+	return &sequenceDedup{
+		seen:  make(map[int64]struct{}, window),
+		order: make([]int64, 0, window),
+		next:  0,
+		max:   0,
+	}
+}
+
+// IsDuplicate returns true if the sequence was already seen.
+// Otherwise, the sequence is remembered.
+//
+// A backward jump beyond the window is treated as the server restart,
+// and the remembered sequences are reset.
+func (d *sequenceDedup) IsDuplicate(seq int64) bool {
+	if seq <= 0 {
+		return false // no sequence
+	}
+
+	if seq < d.max-int64(cap(d.order)) {
+		d.reset() // the sequence is reset by the server restart
+	}
+
+	if _, ok := d.seen[seq]; ok {
+		return true
+	}
+
+	if len(d.order) < cap(d.order) {
+		d.order = append(d.order, seq)
+	} else {
+		delete(d.seen, d.order[d.next]) // forget the oldest
+		d.order[d.next] = seq
+		d.next = (d.next + 1) % len(d.order)
+	}
+
+	d.seen[seq] = struct{}{}
+	d.max = max(d.max, seq)
+
+	return false
+}
+
+// reset forgets all remembered sequences.
+func (d *sequenceDedup) reset() {
+	clear(d.seen)
+	d.order = d.order[:0]
+	d.next = 0
+	d.max = 0
+}
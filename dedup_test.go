@@ -0,0 +1,37 @@
+package esl
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSequenceDedup(t *testing.T) {
+	tests := []struct {
+		name string
+		seqs []int64
+		want []bool
+	}{
+		{"no sequence", []int64{0, 0, -1}, []bool{false, false, false}},
+		{"inside window", []int64{1, 2, 3, 2, 1}, []bool{false, false, false, true, true}},
+		{"just outside window", []int64{1, 2, 3, 4, 1}, []bool{false, false, false, false, false}},
+		{"ring wrap-around", []int64{1, 2, 3, 4, 5, 6, 7, 5, 6, 7, 4},
+			[]bool{false, false, false, false, false, false, false, true, true, true, false}},
+		{"reset on jump", []int64{100, 101, 102, 1, 2, 1, 101},
+			[]bool{false, false, false, false, false, true, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dedup := newSequenceDedup(3)
+
+			got := make([]bool, 0, len(tt.seqs))
+			for _, seq := range tt.seqs {
+				got = append(got, dedup.IsDuplicate(seq))
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("IsDuplicate(%v) = %v, want %v", tt.seqs, got, tt.want)
+			}
+		})
+	}
+}
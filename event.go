@@ -1,20 +1,103 @@
 package esl
 
 import (
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"maps"
+	"net/textproto"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 // Event represents an ESL event with headers and a body.
+//
+// The private keys start with an underscore, like the body. The events parsed
+// with the lazy decoding or the case-insensitive headers also keep the parser
+// markers, skipped by SortedKeys and AsMap, which should be used to iterate
+// the headers instead of the map itself.
 type Event map[string]string
 
 // Get returns the value associated with the given key from the Event's headers.
+// The value of the event parsed with the lazy decoding is URL-decoded on every call.
+//
+// If the key is not found in the event parsed with the case-insensitive
+// headers, Get looks up its canonical form, so it's matched by any key case.
 func (e Event) Get(key string) string {
-	return e[key]
+	value, ok := e[key]
+	if _, canonical := e[canonicalKey]; !ok && canonical {
+		if canonical := canonicalHeaderKey(key); canonical != key {
+			value = e[canonical]
+		}
+	}
+
+	// the lazily decoded header value
+	if strings.IndexByte(value, '%') >= 0 && e.isEncoded(key) {
+		return decodeValue(value)
+	}
+
+	return value
+}
+
+// isEncoded returns true if the value of the key is kept URL-encoded
+// by the lazy decoding parser.
+func (e Event) isEncoded(key string) bool {
+	for keys := e[encodedKey]; keys != ""; {
+		var name string
+		if name, keys, _ = strings.Cut(keys, "\n"); name == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setLiteral sets the key to the value, which is never URL-decoded by Get.
+func (e Event) setLiteral(key, value string) {
+	e[key] = value
+
+	if e.isEncoded(key) {
+		keys := strings.Split(e[encodedKey], "\n")
+		e[encodedKey] = strings.Join(slices.DeleteFunc(keys, func(name string) bool {
+			return name == key
+		}), "\n")
+	}
+}
+
+// decodeAll decodes in place the values kept URL-encoded by the lazy decoding.
+func (e Event) decodeAll() {
+	for keys := e[encodedKey]; keys != ""; {
+		var name string
+		name, keys, _ = strings.Cut(keys, "\n")
+
+		if value, ok := e[name]; ok {
+			e[name] = decodeValue(value)
+		}
+	}
+
+	delete(e, encodedKey)
+}
+
+// isMarkerKey returns true for the private keys describing how the event
+// was parsed, which are not a part of the event data.
+func isMarkerKey(key string) bool {
+	return key == encodedKey || key == canonicalKey
+}
+
+// GetInt returns the value associated with the given key as an int.
+//
+// Returns false if the key is missing or the value is not an integer.
+func (e Event) GetInt(key string) (int, bool) {
+	i, err := strconv.Atoi(e.Get(key))
+	if err != nil {
+		return 0, false
+	}
+
+	return i, true
 }
 
 // Event constants.
@@ -24,9 +107,15 @@ const (
 	eventSubclassKey  = "Event-Subclass"
 	eventSequenceKey  = "Event-Sequence"
 	eventTimestampKey = "Event-Date-Timestamp"
+	eventDateGMTKey   = "Event-Date-GMT"
+	eventDateLocalKey = "Event-Date-Local"
 	eventJobUUIDKey   = "Job-UUID"
 	variableKeyPrefix = "variable_"
 	bodyKey           = "_body"
+	sourceKey         = "_source"
+	rawKey            = "_raw"
+	encodedKey        = "_encoded"
+	canonicalKey      = "_canonical"
 )
 
 // Name returns the name of the event.
@@ -38,6 +127,17 @@ func (e Event) Name() string {
 	return e.Get(eventNameKey)
 }
 
+// BaseName returns the Event-Name header, which is "CUSTOM" for the custom events.
+func (e Event) BaseName() string {
+	return e.Get(eventNameKey)
+}
+
+// Subclass returns the subclass of the custom event.
+// Returns an empty string for non-custom events.
+func (e Event) Subclass() string {
+	return e.Get(eventSubclassKey)
+}
+
 // ContentType returns the content type of the event.
 func (e Event) ContentType() string {
 	return e.Get(contentLengthKey)
@@ -48,6 +148,32 @@ func (e Event) Body() string {
 	return e.Get(bodyKey)
 }
 
+// BodyBytes returns a copy of the body of the event as a byte slice,
+// or nil if the event has no body.
+//
+// The event is shared between the subscribers, so the body is copied
+// to be safely modified.
+func (e Event) BodyBytes() []byte {
+	return copyBytes(e.Body())
+}
+
+// Raw returns the original event frame body as it was read from the connection,
+// before parsing. It's set only with Monitor.WithRetainRawFrame, otherwise it returns nil.
+//
+// The returned slice is a copy, like with BodyBytes.
+func (e Event) Raw() []byte {
+	return copyBytes(e.Get(rawKey))
+}
+
+// copyBytes returns the bytes of the string, or nil if it's empty.
+func copyBytes(s string) []byte {
+	if s == "" {
+		return nil
+	}
+
+	return []byte(s)
+}
+
 // ContentLength returns the length of the body in the Event.
 func (e Event) ContentLength() int {
 	return len(e.Body())
@@ -61,25 +187,304 @@ func (e Event) Sequence() int64 {
 }
 
 // Timestamp returns the timestamp of the event.
+//
+// The Event-Date-Timestamp header is expected in microseconds, but the values
+// in seconds or milliseconds are detected by their magnitude.
+// If it's missing, the Event-Date-GMT and Event-Date-Local headers are used.
+// Returns the zero time if the timestamp can't be parsed.
 func (e Event) Timestamp() time.Time {
-	ts := e.Get(eventTimestampKey)
-	if i, err := strconv.ParseInt(ts, 10, 64); err == nil {
-		return time.UnixMicro(i)
+	if us := e.TimestampMicros(); us != 0 {
+		return time.UnixMicro(us)
+	}
+
+	if t, err := time.Parse(time.RFC1123, e.Get(eventDateGMTKey)); err == nil {
+		return t
+	}
+
+	if t, err := e.LocalTime(); err == nil {
+		return t
 	}
 
 	return time.Time{}
 }
 
+// TimestampMicros returns the Event-Date-Timestamp header in microseconds
+// since the Unix epoch, or zero if it's missing or invalid.
+//
+// The values in seconds or milliseconds are detected by their magnitude
+// and converted, like for Timestamp. It allows to compare and sort the events
+// without constructing the time.Time values.
+func (e Event) TimestampMicros() int64 {
+	i, err := strconv.ParseInt(e.Get(eventTimestampKey), 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	const (
+		maxSeconds = 1e11 // year 5138 in seconds
+		maxMillis  = 1e14 // year 5138 in milliseconds
+	)
+
+	switch {
+	case i < maxSeconds:
+		return i * int64(time.Second/time.Microsecond)
+	case i < maxMillis:
+		return i * int64(time.Millisecond/time.Microsecond)
+	default:
+		return i
+	}
+}
+
+// LocalTime parses the Event-Date-Local header in the local time zone.
+func (e Event) LocalTime() (time.Time, error) {
+	const layout = time.DateTime // 2006-01-02 15:04:05
+
+	t, err := time.ParseInLocation(layout, e.Get(eventDateLocalKey), time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("event local time: %w", err)
+	}
+
+	return t, nil
+}
+
 // Variable returns the value of the variable with the given name.
 func (e Event) Variable(name string) string {
 	return e.Get(variableKeyPrefix + name)
 }
 
+// MediaFilePath returns the file path of the PLAYBACK_START, PLAYBACK_STOP,
+// RECORD_START and RECORD_STOP events.
+//
+// Returns an empty string for other events.
+func (e Event) MediaFilePath() string {
+	switch e.Name() {
+	case "PLAYBACK_START", "PLAYBACK_STOP":
+		return e.Get("Playback-File-Path")
+	case "RECORD_START", "RECORD_STOP":
+		return e.Get("Record-File-Path")
+	default:
+		return ""
+	}
+}
+
+// RecordSeconds returns the duration of the recording in seconds for the RECORD_STOP event.
+//
+// Returns false for other events or if the duration is not set.
+func (e Event) RecordSeconds() (int, bool) {
+	if e.Name() != "RECORD_STOP" {
+		return 0, false
+	}
+
+	return e.GetInt(variableKeyPrefix + "record_seconds")
+}
+
+// Source returns the source tag of the monitor that received the event.
+//
+// See Monitor.WithSourceTag.
+func (e Event) Source() string {
+	return e.Get(sourceKey)
+}
+
+// Application returns the dialplan application name, its arguments and
+// the response of the CHANNEL_EXECUTE and CHANNEL_EXECUTE_COMPLETE events.
+//
+// The response is set only for the CHANNEL_EXECUTE_COMPLETE event.
+//
+//nolint:nonamedreturns // named for documentation
+func (e Event) Application() (name, data, response string) {
+	return e.Get("Application"), e.Get("Application-Data"), e.Get("Application-Response")
+}
+
+// Presence returns the presence information of the PRESENCE_IN,
+// PRESENCE_OUT and PRESENCE_PROBE events.
+//
+// Returns empty strings for other events.
+//
+//nolint:nonamedreturns // named for documentation
+func (e Event) Presence() (from, status, rpid string) {
+	switch e.Name() {
+	case "PRESENCE_IN", "PRESENCE_OUT", "PRESENCE_PROBE":
+		return e.Get("from"), e.Get("status"), e.Get("rpid")
+	default:
+		return "", "", ""
+	}
+}
+
+// Registration returns the registration information of the sofia::register,
+// sofia::unregister and sofia::expire custom events.
+//
+// Returns empty strings for other events.
+//
+//nolint:nonamedreturns // named for documentation
+func (e Event) Registration() (user, realm, contact string) {
+	switch e.Name() {
+	case "sofia::register", "sofia::unregister", "sofia::expire":
+	default:
+		return "", "", ""
+	}
+
+	if user = e.Get("username"); user == "" {
+		user = e.Get("from-user")
+	}
+
+	if realm = e.Get("realm"); realm == "" {
+		realm = e.Get("from-host")
+	}
+
+	return user, realm, e.Get("contact")
+}
+
+// Gateway returns the sofia gateway name of the channel or the gateway event.
+//
+// It checks the sip_gateway and sip_gateway_name variables of the channel
+// events, set by the different FreeSWITCH versions, and the Gateway header
+// of the sofia::gateway_state custom events.
+func (e Event) Gateway() string {
+	for _, name := range []string{"sip_gateway", "sip_gateway_name"} {
+		if gateway := e.Variable(name); gateway != "" {
+			return gateway
+		}
+	}
+
+	return e.Get("Gateway")
+}
+
+// SofiaProfile returns the sofia profile name of the channel or the sofia event.
+//
+// It checks the sofia_profile_name variable of the channel events and
+// the profile-name header of the sofia custom events.
+func (e Event) SofiaProfile() string {
+	if profile := e.Variable("sofia_profile_name"); profile != "" {
+		return profile
+	}
+
+	return e.Get("profile-name")
+}
+
+// Conference returns the conference name, member ID and action of the
+// conference events.
+//
+//nolint:nonamedreturns // named for documentation
+func (e Event) Conference() (name, memberID, action string) {
+	return e.Get("Conference-Name"), e.Get("Member-ID"), e.Get("Action")
+}
+
+// MediaBug returns the media bug function of the MEDIA_BUG_START and MEDIA_BUG_STOP events.
+func (e Event) MediaBug() string {
+	return e.Get("Media-Bug-Function")
+}
+
+// IsHangup returns true if the event is a terminal event for the channel:
+// CHANNEL_HANGUP, CHANNEL_HANGUP_COMPLETE or CHANNEL_DESTROY.
+func (e Event) IsHangup() bool {
+	switch e.Name() {
+	case "CHANNEL_HANGUP", "CHANNEL_HANGUP_COMPLETE", "CHANNEL_DESTROY":
+		return true
+	default:
+		return false
+	}
+}
+
+// IsAnswer returns true if the event is CHANNEL_ANSWER.
+func (e Event) IsAnswer() bool {
+	return e.Name() == "CHANNEL_ANSWER"
+}
+
+// Direction returns the direction of the channel leg in the lower case,
+// "inbound" or "outbound", or an empty string if it's unknown.
+//
+// It checks the Call-Direction header, the direction variable and
+// the Caller-Direction header, which are set by different event types.
+func (e Event) Direction() string {
+	for _, direction := range []string{
+		e.Get("Call-Direction"),
+		e.Variable("direction"),
+		e.Get("Caller-Direction"),
+	} {
+		if direction != "" {
+			return strings.ToLower(direction)
+		}
+	}
+
+	return ""
+}
+
+// IsDTMF returns true if the event is DTMF.
+func (e Event) IsDTMF() bool {
+	return e.Name() == "DTMF"
+}
+
+// IsTalk returns true if the event is TALK or NOTALK of the voice activity detection.
+func (e Event) IsTalk() bool {
+	switch e.Name() {
+	case "TALK", "NOTALK":
+		return true
+	default:
+		return false
+	}
+}
+
+// IsDetectedSpeech returns true if the event is DETECTED_SPEECH.
+func (e Event) IsDetectedSpeech() bool {
+	return e.Name() == "DETECTED_SPEECH"
+}
+
+// AnswerState returns the Answer-State header of the channel event,
+// like "ringing", "early", "answered" or "hangup".
+func (e Event) AnswerState() string {
+	return e.Get("Answer-State")
+}
+
+// IsAnswered returns true if the channel of the event was answered.
+//
+// It checks the "answered" Answer-State, and the answer_epoch variable for
+// the hangup events, where the state is "hangup" for both answered and
+// unanswered legs. FreeSWITCH sets the variable to "0" if the leg was
+// never answered.
+func (e Event) IsAnswered() bool {
+	if e.AnswerState() == "answered" {
+		return true
+	}
+
+	epoch := e.Variable("answer_epoch")
+
+	return epoch != "" && epoch != "0"
+}
+
 // IsCustom returns true if the event is a custom event.
 func (e Event) IsCustom() bool {
 	return e[eventNameKey] == "CUSTOM"
 }
 
+// AsMap returns a shallow copy of the Event as a plain map.
+//
+// The copy includes the body and other private keys, like "_body",
+// so it can be modified without affecting the event shared between
+// the subscribers. The values kept encoded by the lazy decoding are decoded.
+func (e Event) AsMap() map[string]string {
+	if e == nil {
+		return nil
+	}
+
+	m := Event(maps.Clone(map[string]string(e)))
+	m.decodeAll()
+	delete(m, canonicalKey)
+
+	return m
+}
+
+// MarshalJSON returns the JSON object with the headers of the Event,
+// with the values decoded as by AsMap.
+func (e Event) MarshalJSON() ([]byte, error) {
+	if _, ok := e[encodedKey]; !ok {
+		if _, ok := e[canonicalKey]; !ok {
+			return json.Marshal(map[string]string(e)) //nolint:wrapcheck // never for the map of strings
+		}
+	}
+
+	return json.Marshal(e.AsMap()) //nolint:wrapcheck // never for the map of strings
+}
+
 // LogValue returns the log value of the Event.
 //
 // It returns a slog.Value that contains the name and sequence of the Event.
@@ -97,9 +502,102 @@ func (e Event) LogValue() slog.Value {
 	return slog.GroupValue(attr...)
 }
 
-// parseEvent parses the given body as an ESL event and returns it.
+// Fields returns the same fields as LogValue, and the content length,
+// as alternating key and value pairs for the loggers not supporting slog:
+//
+//	sugar.Infow("event", e.Fields()...)
+func (e Event) Fields() []any {
+	fields := make([]any, 0, 8) //nolint:mnd // four pairs at most
+	fields = append(fields,
+		"name", e.Name(),
+		"sequence", e.Sequence(),
+	)
+
+	if jobUUID := e.Get(eventJobUUIDKey); jobUUID != "" {
+		fields = append(fields, "job-uuid", jobUUID)
+	}
+
+	if length := e.ContentLength(); length > 0 {
+		fields = append(fields, "content-length", length)
+	}
+
+	return fields
+}
+
+// SortedKeys returns the header keys of the Event sorted in ascending order.
+func (e Event) SortedKeys() []string {
+	keys := make([]string, 0, len(e))
+	for key := range e {
+		if !isMarkerKey(key) {
+			keys = append(keys, key)
+		}
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}
+
+// LogHeaders returns the log value with all headers of the Event sorted by key.
+//
+// Unlike LogValue, it gives the full and stable dump of the event for debugging:
+//
+//	logger.Debug("event", slog.Any("event", e.LogHeaders()))
+func (e Event) LogHeaders() slog.Value {
+	attr := make([]slog.Attr, 0, len(e))
+	for _, key := range e.SortedKeys() {
+		attr = append(attr, slog.String(key, e.Get(key)))
+	}
+
+	return slog.GroupValue(attr...)
+}
+
+// parseEvent parses the given body as an ESL event with all headers and returns it.
 func parseEvent(body string) (Event, error) {
-	headers := make(map[string]string, upcomingHeaderKeys(body)+1)
+	return eventParser{}.Parse(body) //nolint:exhaustruct
+}
+
+// eventParser parses the plain ESL events with the options.
+type eventParser struct {
+	headers   map[string]struct{} // whitelisted headers, nil to keep all
+	canonical bool                // convert the header keys to the canonical form
+	raw       bool                // keep the original frame body
+	lazy      bool                // keep the header values URL-encoded
+	varPrefix string              // channel variables prefix to replace with "variable_"
+	noBodies  map[string]struct{} // event names to discard the body, empty for all
+	noBody    bool                // discard the bodies of the noBodies events
+}
+
+// Parse parses the given body as an ESL event and returns it.
+//
+// The folded header lines, starting with a space or a tab, are appended
+// to the previous header value separated by a single space.
+func (p eventParser) Parse(body string) (Event, error) {
+	var headers map[string]string
+	if p.headers != nil {
+		headers = make(map[string]string, len(p.headers)+1)
+	} else {
+		headers = make(map[string]string, upcomingHeaderKeys(body)+1)
+	}
+
+	if p.raw {
+		headers[rawKey] = body // before the body is consumed
+	}
+
+	if p.canonical {
+		headers[canonicalKey] = "" // the keys are matched by Get in any case
+	}
+
+	value := headerValue
+	if p.lazy {
+		value = strings.TrimSpace
+	}
+
+	var (
+		lastKey       string // to unfold the continuation lines
+		skipped       bool   // the last header is not whitelisted
+		contentLength string // the body length, even if not whitelisted
+	)
 
 	for len(body) > 0 {
 		var header string
@@ -112,26 +610,128 @@ func parseEvent(body string) (Event, error) {
 			break // the end of headers
 		}
 
+		if header[0] == ' ' || header[0] == '\t' {
+			if lastKey == "" && !skipped {
+				return headers, &ProtocolError{Msg: "malformed header line", Line: header}
+			}
+
+			if !skipped {
+				headers[lastKey] += " " + value(header)
+			}
+
+			continue
+		}
+
 		idx := strings.IndexByte(header, ':')
 		if idx <= 0 {
-			return headers, fmt.Errorf("malformed header line: %q", header)
+			return headers, &ProtocolError{Msg: "malformed header line", Line: header}
 		}
 
-		key, value := header[:idx], strings.TrimSpace(header[idx+1:])
-		if v, err := url.PathUnescape(value); err == nil {
-			value = v
+		key := header[:idx]
+		if p.varPrefix != "" && strings.HasPrefix(key, p.varPrefix) {
+			key = variableKeyPrefix + key[len(p.varPrefix):]
+		}
+
+		if p.canonical {
+			key = canonicalHeaderKey(key)
+		}
+
+		if key == contentLengthKey {
+			contentLength = strings.TrimSpace(header[idx+1:])
+		}
+
+		if _, ok := p.headers[key]; p.headers != nil && !ok {
+			skipped = true
+
+			continue // fast path: the header value is not decoded
 		}
 
-		headers[key] = value
+		headers[key] = value(header[idx+1:])
+		lastKey, skipped = key, false
 	}
 
-	if clen, err := strconv.Atoi(headers["Content-Length"]); err == nil && clen > 0 {
-		headers[bodyKey] = body[:clen]
+	if p.lazy {
+		encodedKeys(headers)
+	}
+
+	if clen, err := strconv.Atoi(contentLength); err == nil && clen > 0 && !p.discardBody(headers) {
+		headers[bodyKey] = body[:min(clen, len(body))]
 	}
 
 	return headers, nil
 }
 
+// canonicalHeaderKey returns the canonical form of the header key.
+//
+// The "variable_" prefix is matched case-insensitively and converted
+// to the lower case, keeping the variable name as is. The other keys
+// are converted like textproto.CanonicalMIMEHeaderKey: the first letter and
+// any letter following a hyphen are upper case, the rest are lower case.
+// The keys with spaces or invalid characters are returned unchanged.
+func canonicalHeaderKey(key string) string {
+	const prefixLen = len(variableKeyPrefix)
+	if len(key) >= prefixLen && strings.EqualFold(key[:prefixLen], variableKeyPrefix) {
+		if key[:prefixLen] == variableKeyPrefix {
+			return key // already canonical
+		}
+
+		return variableKeyPrefix + key[prefixLen:]
+	}
+
+	return textproto.CanonicalMIMEHeaderKey(key)
+}
+
+// discardBody returns true if the body of the parsed event should be discarded.
+func (p eventParser) discardBody(headers Event) bool {
+	if !p.noBody {
+		return false
+	}
+
+	if len(p.noBodies) == 0 {
+		return true // all events
+	}
+
+	_, ok := p.noBodies[headers.Name()]
+
+	return ok
+}
+
+// headerValue returns the trimmed and URL-decoded header value.
+func headerValue(value string) string {
+	return decodeValue(strings.TrimSpace(value))
+}
+
+// encodedKeys lists the keys of the headers with the URL-encoded values
+// to be decoded by Get. The private keys are never encoded.
+func encodedKeys(headers map[string]string) {
+	var keys strings.Builder
+
+	for key, value := range headers {
+		if strings.IndexByte(value, '%') < 0 || strings.HasPrefix(key, "_") {
+			continue
+		}
+
+		if keys.Len() > 0 {
+			keys.WriteByte('\n')
+		}
+
+		keys.WriteString(key)
+	}
+
+	if keys.Len() > 0 {
+		headers[encodedKey] = keys.String()
+	}
+}
+
+// decodeValue returns the URL-decoded value, or the value as is if it's invalid.
+func decodeValue(value string) string {
+	if v, err := url.PathUnescape(value); err == nil {
+		value = v
+	}
+
+	return value
+}
+
 // upcomingHeaderKeys returns the number of upcoming header keys in the given byte slice.
 func upcomingHeaderKeys(body string) int {
 	const maxHeaders = 1000
@@ -165,7 +765,38 @@ func upcomingHeaderKeys(body string) int {
 	return headersCount
 }
 
+// RegisterEventName adds the names to the set of the known event names.
+//
+// The known names are subscribed as is, and all other names are subscribed
+// as the CUSTOM event subclasses. Use it for the events added by the newer
+// FreeSWITCH versions.
+//
+// It's safe for concurrent use, including with the running monitors.
+func RegisterEventName(names ...string) {
+	eventNamesMu.Lock()
+	defer eventNamesMu.Unlock()
+
+	for _, name := range names {
+		eventNames[name] = struct{}{}
+	}
+}
+
+// isEventName returns true if the name is the known event name.
+func isEventName(name string) bool {
+	eventNamesMu.RLock()
+	defer eventNamesMu.RUnlock()
+
+	_, ok := eventNames[name]
+
+	return ok
+}
+
+// eventNamesMu protects eventNames.
+var eventNamesMu sync.RWMutex //nolint:gochecknoglobals
+
 // eventNames is a map that contains the predefined names of various events as keys.
+//
+// Use isEventName and RegisterEventName to access it.
 var eventNames = map[string]struct{}{ //nolint:gochecknoglobals
 	// spell-checker:disable
 	// "CUSTOM":                   {},
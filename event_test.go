@@ -0,0 +1,177 @@
+package esl
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseEventFolded(t *testing.T) {
+	const body = "Event-Name: CHANNEL_CREATE\n" +
+		"variable_sip_full_via: SIP/2.0/UDP 10.0.0.1:5060;rport=5060;\n" +
+		"\tbranch=z9hG4bK-524287-1\n" +
+		"Unique-ID: 8d1b4e6c\n" +
+		"\n"
+
+	event, err := parseEvent(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const want = "SIP/2.0/UDP 10.0.0.1:5060;rport=5060; branch=z9hG4bK-524287-1"
+	if got := event.Variable("sip_full_via"); got != want {
+		t.Errorf("sip_full_via = %q, want %q", got, want)
+	}
+
+	if got := event.Get("Unique-ID"); got != "8d1b4e6c" {
+		t.Errorf("Unique-ID = %q, want %q", got, "8d1b4e6c")
+	}
+
+	if _, err := parseEvent(" folded: without header\n\n"); err == nil {
+		t.Error("expected error for the folded first line")
+	}
+}
+
+func TestParseEventCaseInsensitive(t *testing.T) {
+	const body = "event-name: CHANNEL_CREATE\n" +
+		"UNIQUE-ID: 8d1b4e6c\n" +
+		"Variable_sip_from_user: 1000\n" +
+		"\n"
+
+	event, err := eventParser{canonical: true}.Parse(body) //nolint:exhaustruct
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := event.Name(); got != "CHANNEL_CREATE" {
+		t.Errorf("Name() = %q, want %q", got, "CHANNEL_CREATE")
+	}
+
+	for _, key := range []string{"Unique-ID", "unique-id", "Unique-Id"} {
+		if got := event.Get(key); got != "8d1b4e6c" {
+			t.Errorf("Get(%q) = %q, want %q", key, got, "8d1b4e6c")
+		}
+	}
+
+	if got := event.Variable("sip_from_user"); got != "1000" {
+		t.Errorf("sip_from_user = %q, want %q", got, "1000")
+	}
+
+	// the keys of the other events are case-sensitive
+	if got := (Event{"Unique-ID": "8d1b4e6c"}).Get("unique-id"); got != "" {
+		t.Errorf("Get(%q) = %q, want empty", "unique-id", got)
+	}
+}
+
+func TestParseEventLazy(t *testing.T) {
+	const body = "Event-Name: CHANNEL_CREATE\n" +
+		"Caller-Caller-ID-Name: John%20Doe\n" +
+		"Content-Length: 5\n" +
+		"\n" +
+		"50%25"
+
+	event, err := eventParser{lazy: true}.Parse(body) //nolint:exhaustruct
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := event["Caller-Caller-ID-Name"]; got != "John%20Doe" {
+		t.Errorf("the stored value = %q, want encoded", got)
+	}
+
+	if got := event.Get("Caller-Caller-ID-Name"); got != "John Doe" {
+		t.Errorf("Get() = %q, want %q", got, "John Doe")
+	}
+
+	if got := event.Body(); got != "50%25" {
+		t.Errorf("Body() = %q, want it as is", got)
+	}
+
+	// the outputs are decoded and have no parser markers
+	if got := event.AsMap(); got["Caller-Caller-ID-Name"] != "John Doe" || got[encodedKey] != "" {
+		t.Errorf("AsMap() = %q, want decoded", got)
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := string(data); !strings.Contains(got, `"John Doe"`) || strings.Contains(got, encodedKey) {
+		t.Errorf("JSON = %s, want decoded", got)
+	}
+
+	// the values set later are never decoded
+	event.setLiteral("Caller-Caller-ID-Name", "100%20")
+	if got := event.Get("Caller-Caller-ID-Name"); got != "100%20" {
+		t.Errorf("Get() = %q, want the literal value", got)
+	}
+}
+
+// benchmarkEventBody returns a realistic CHANNEL_CREATE event body with
+// the channel variables.
+func benchmarkEventBody() string {
+	var body strings.Builder
+
+	body.WriteString("Event-Name: CHANNEL_CREATE\n" +
+		"Core-UUID: 6f3c5a52-1f6e-4e0c-a2e8-4b8c0f0f9a11\n" +
+		"FreeSWITCH-Hostname: fs01\n" +
+		"FreeSWITCH-IPv4: 10.0.0.1\n" +
+		"Event-Date-Local: 2024-05-01%2012%3A00%3A00\n" +
+		"Event-Date-GMT: Wed,%2001%20May%202024%2009%3A00%3A00%20GMT\n" +
+		"Event-Date-Timestamp: 1714554000000000\n" +
+		"Event-Calling-File: switch_core_state_machine.c\n" +
+		"Event-Sequence: 12345\n" +
+		"Unique-ID: 8d1b4e6c-2f0a-4d6b-9c57-9b1b0d2c3e4f\n" +
+		"Call-Direction: inbound\n" +
+		"Caller-Caller-ID-Name: John%20Doe\n" +
+		"Caller-Caller-ID-Number: 1000\n" +
+		"Caller-Destination-Number: 2000\n")
+
+	for i := range 100 {
+		fmt.Fprintf(&body, "variable_var_%d: sip%%3A%d%%40example.com%%3Btransport%%3Dudp\n", i, i)
+	}
+
+	body.WriteString("\n")
+
+	return body.String()
+}
+
+func BenchmarkParseEvent(b *testing.B) {
+	body := benchmarkEventBody()
+
+	b.ReportAllocs()
+
+	for range b.N {
+		if _, err := parseEvent(body); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseEventWhitelist(b *testing.B) {
+	body := benchmarkEventBody()
+	parser := New("localhost", "").WithHeaderWhitelist(eventSequenceKey).parser
+
+	b.ReportAllocs()
+
+	for range b.N {
+		if _, err := parser.Parse(body); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseEventLazy(b *testing.B) {
+	body := benchmarkEventBody()
+	parser := New("localhost", "").WithLazyDecode().parser
+
+	b.ReportAllocs()
+
+	for range b.N {
+		if _, err := parser.Parse(body); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package esl
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync/atomic"
+)
+
+// eventLog writes the dispatched events as JSON lines in the background.
+type eventLog struct {
+	w       io.Writer
+	lines   chan []byte   // buffered lines to write, nil if not started
+	done    chan struct{} // closed when the writer exits
+	dropped atomic.Uint64 // lines dropped on the full buffer
+}
+
+// WithEventLog writes every dispatched event as a JSON object on a single line
+// to w, for example, to the audit log file. A nil writer disables the log.
+//
+// The lines are written in the background from the buffer, so the slow writer
+// doesn't stall the read loop. If the buffer is full, the event is dropped and
+// counted in Stats.EventLogDropped. The buffered lines are written before Run returns.
+func (m *Monitor) WithEventLog(w io.Writer) *Monitor {
+	m.eventLog = nil
+	if w != nil {
+		m.eventLog = &eventLog{w: w} //nolint:exhaustruct
+	}
+
+	return m
+}
+
+// start starts the background writer and returns the function to stop it,
+// which waits for the buffered lines to be written.
+func (l *eventLog) start(log *slog.Logger) func() {
+	const bufferSize = 1024
+
+	l.lines = make(chan []byte, bufferSize)
+	l.done = make(chan struct{})
+
+	go func() {
+		defer close(l.done)
+
+		for line := range l.lines {
+			if _, err := l.w.Write(line); err != nil {
+				log.Warn("esl: event log", slog.String("error", err.Error()))
+			}
+		}
+	}()
+
+	return func() {
+		close(l.lines)
+		<-l.done
+	}
+}
+
+// Write adds the event to the write buffer, or drops it if the buffer is full.
+//
+// Called only from the read loop.
+func (l *eventLog) Write(e Event) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return // never for the map of strings
+	}
+
+	select {
+	case l.lines <- append(line, '\n'):
+	default:
+		l.dropped.Add(1)
+	}
+}
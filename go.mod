@@ -1,3 +1,3 @@
 module github.com/mdigger/eslmon
 
-go 1.22.2
+go 1.23
@@ -3,10 +3,12 @@ package esl
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
@@ -18,36 +20,137 @@ var (
 	ErrAccessDenied    = errors.New("access denied")
 	ErrInvalidPassword = errors.New("invalid password")
 	ErrTimeout         = errors.New("timeout")
+	ErrLineTooLong     = errors.New("header line too long")
+	ErrTruncatedBody   = errors.New("truncated response body")
+	ErrUnterminated    = errors.New("unterminated response header")
+	ErrWrite           = errors.New("write failed")
 )
 
+// ProtocolError is the error of parsing the data sent by the server.
+//
+// It means the server sent an invalid frame rather than the network failure,
+// so reconnecting may not help.
+type ProtocolError struct {
+	Msg  string // the error description
+	Line string // the offending line or value
+	Err  error  // the underlying error, nil if none
+}
+
+func (e *ProtocolError) Error() string {
+	if e.Err != nil {
+		return e.Msg + ": " + strconv.Quote(e.Line) + ": " + e.Err.Error()
+	}
+
+	return e.Msg + ": " + strconv.Quote(e.Line)
+}
+
+func (e *ProtocolError) Unwrap() error { return e.Err }
+
+// DefaultMaxLineLength is the default maximum length of the header line.
+const DefaultMaxLineLength = 4 << 20 // 4 MB
+
 // Conn represents an ESL connection.
 type Conn struct {
 	r          *bufio.Reader // response reader
 	w          *bufio.Writer // command writer
 	mu         sync.Mutex    // to protect the writer
 	cmdTimeout time.Duration // command timeout
+	deadliner  readDeadliner // to set the read deadline, nil if not supported
+	maxLine    int           // maximum header line length
+	headers    bool          // keep all response headers
+	decompress bool          // decompress the gzip encoded bodies
+	tee        io.Writer     // copy of the written commands, nil if none
 }
 
-// NewConn returns a new authenticated ESL connection.
-func NewConn(ctx context.Context, rw io.ReadWriter, password string, cmdTimeout time.Duration) (*Conn, error) {
+// Option configures the connection.
+type Option func(*Conn)
+
+// WithMaxLineLength sets the maximum length of the header line.
+// A non-positive value sets the DefaultMaxLineLength.
+func WithMaxLineLength(n int) Option {
+	return func(c *Conn) {
+		if n <= 0 {
+			n = DefaultMaxLineLength
+		}
+
+		c.maxLine = n
+	}
+}
+
+// readDeadliner is implemented by connections supporting the read deadline, like net.Conn.
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// WithHeaders keeps all response headers in the Response.Headers map.
+func WithHeaders() Option {
+	return func(c *Conn) {
+		c.headers = true
+	}
+}
+
+// WithDecompression decompresses the response body if the response has
+// the "Content-Encoding: gzip" header.
+func WithDecompression() Option {
+	return func(c *Conn) {
+		c.decompress = true
+	}
+}
+
+// WithWriteTee writes a copy of all data sent to the connection to w,
+// including the authentication command with the password.
+// It's intended for the tests.
+func WithWriteTee(w io.Writer) Option {
+	return func(c *Conn) {
+		c.tee = w
+	}
+}
+
+// NewConn returns a new authenticated ESL connection and the server reply
+// to the authentication.
+func NewConn(
+	ctx context.Context, rw io.ReadWriter, password string, cmdTimeout time.Duration, opts ...Option,
+) (*Conn, Response, error) {
 	conn := &Conn{
 		r:          bufio.NewReader(rw),
 		w:          bufio.NewWriter(rw),
 		mu:         sync.Mutex{},
 		cmdTimeout: cmdTimeout,
+		deadliner:  nil,
+		maxLine:    DefaultMaxLineLength,
+		headers:    false,
+		decompress: false,
+		tee:        nil,
+	}
+
+	for _, opt := range opts {
+		opt(conn)
+	}
+
+	if conn.tee != nil {
+		conn.w.Reset(io.MultiWriter(rw, conn.tee))
+	}
+
+	if deadliner, ok := rw.(readDeadliner); ok {
+		conn.deadliner = deadliner
 	}
 
 	// authenticate
+	var reply Response
 	if err := conn.withTimeout(ctx, func() error {
-		return conn.auth(password)
+		var err error
+		reply, err = conn.auth(password)
+
+		return err
 	}); err != nil {
-		return nil, err
+		return nil, Response{}, err
 	}
 
-	return conn, nil
+	return conn, reply, nil
 }
 
 // Write writes a command to the connection.
+// The write errors wrap ErrWrite, meaning the connection is broken.
 //
 //nolint:errcheck // writing to the buffer never returns an error
 func (c *Conn) Write(cmd string) error {
@@ -62,7 +165,46 @@ func (c *Conn) Write(cmd string) error {
 	c.w.WriteString("\n\n")
 
 	if err := c.w.Flush(); err != nil {
-		return fmt.Errorf("send: %w", err)
+		return fmt.Errorf("%w: %w", ErrWrite, err)
+	}
+
+	return nil
+}
+
+// WriteBatch writes the commands to the connection with a single flush.
+//
+// The queue function, if not nil, is called under the write lock before
+// anything is sent, so the caller can register the reply waiters in the order
+// of the commands on the wire. If it returns an error, nothing is written and
+// the error is returned as is. The write errors wrap ErrWrite, meaning
+// the connection is broken.
+func (c *Conn) WriteBatch(cmds []string, queue func() error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if queue != nil {
+		if err := queue(); err != nil {
+			return err
+		}
+	}
+
+	for _, cmd := range cmds {
+		if cmd == "" {
+			continue
+		}
+
+		// the buffer is flushed automatically only when it's full
+		if _, err := c.w.WriteString(cmd); err != nil {
+			return fmt.Errorf("%w: %w", ErrWrite, err)
+		}
+
+		if _, err := c.w.WriteString("\n\n"); err != nil {
+			return fmt.Errorf("%w: %w", ErrWrite, err)
+		}
+	}
+
+	if err := c.w.Flush(); err != nil {
+		return fmt.Errorf("%w: %w", ErrWrite, err)
 	}
 
 	return nil
@@ -77,15 +219,34 @@ func (c *Conn) Write(cmd string) error {
 // it reads the specified number of bytes as the response body.
 // Finally, it logs the received response and returns it along
 // with any error encountered during the process.
+//
+// The content type parameters, like charset, are removed, while the original
+// header is kept in the Headers map with WithHeaders.
+//
+// If the connection is closed after the response header without the
+// terminating blank line, the response is returned with the error wrapping
+// ErrUnterminated and io.EOF, as it may be complete. If it's closed in the
+// middle of a header line, io.ErrUnexpectedEOF is returned.
 func (c *Conn) Read() (Response, error) {
 	var (
-		resp          Response
-		contentLength int
+		resp            Response
+		contentLength   int
+		contentEncoding string
 	)
 
 	for {
 		line, err := c.readLine()
 		if err != nil {
+			if errors.Is(err, io.EOF) && resp.ContentType != "" && contentLength == 0 {
+				// the connection is closed after the header without the blank line,
+				// but the response without body is complete
+				return resp, fmt.Errorf("%w: %w", ErrUnterminated, io.EOF)
+			}
+
+			if errors.Is(err, io.EOF) && contentLength > 0 {
+				return resp, fmt.Errorf("%w: %w", ErrTruncatedBody, io.ErrUnexpectedEOF)
+			}
+
 			return resp, err
 		}
 
@@ -100,44 +261,97 @@ func (c *Conn) Read() (Response, error) {
 		// parse response header
 		idx := bytes.IndexByte(line, ':')
 		if idx <= 0 {
-			return resp, fmt.Errorf("malformed header line: %q", line)
+			return resp, &ProtocolError{Msg: "malformed header line", Line: string(line)}
 		}
 
 		key, value := string(line[:idx]), trimLeft(line[idx+1:])
+		if c.headers {
+			if resp.Headers == nil {
+				const headersCapacity = 4
+				resp.Headers = make(map[string]string, headersCapacity)
+			}
+
+			resp.Headers[key] = value
+		}
+
 		switch key {
 		case "Content-Type":
-			resp.ContentType = value
+			resp.ContentType = mediaType(value)
 		case "Reply-Text":
 			resp.Text = value
 		case "Job-UUID":
 			resp.JobUUID = value
+		case "Content-Encoding":
+			contentEncoding = value
 		case "Content-Length":
-			contentLength, err = strconv.Atoi(value)
+			contentLength, err = strconv.Atoi(strings.TrimRight(value, " \t"))
 			if err != nil || contentLength < 0 {
-				return resp, fmt.Errorf("malformed content-length: %q", value)
+				return resp, &ProtocolError{Msg: "malformed content-length", Line: value}
 			}
 		default: // ignore unsupported headers
 		}
 	}
 
-	// read response body
+	// read response body: the zero Content-Length means the empty body
+	// and the next frame starts right after the header
 	if contentLength > 0 {
 		body := make([]byte, contentLength)
 		if _, err := io.ReadFull(c.r, body); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				// the connection is closed in the middle of the frame
+				return resp, fmt.Errorf("%w: %w", ErrTruncatedBody, io.ErrUnexpectedEOF)
+			}
+
 			return resp, fmt.Errorf("failed to read response body: %w", err)
 		}
 
-		resp.Body = string(body)
+		if c.decompress && contentEncoding == "gzip" {
+			decoded, err := gunzip(body)
+			if err != nil {
+				return resp, &ProtocolError{Msg: "decompress body", Line: contentEncoding, Err: err}
+			}
+
+			body = decoded
+		}
+
+		resp.Body, resp.body = string(body), body
 	}
 
 	return resp, nil
 }
 
+// gunzip returns the decompressed gzip data.
+func gunzip(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+	defer zr.Close()
+
+	return io.ReadAll(zr) //nolint:wrapcheck
+}
+
+// SetReadDeadline sets the deadline for the future Read calls.
+// A zero value for t means Read will not time out.
+//
+// It does nothing if the underlying connection doesn't support deadlines.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	if c.deadliner == nil {
+		return nil
+	}
+
+	if err := c.deadliner.SetReadDeadline(t); err != nil {
+		return fmt.Errorf("set read deadline: %w", err)
+	}
+
+	return nil
+}
+
 // Send sends a command to the connection and return Response.
 // It's a shortcut for c.Write and c.Read.
 func (c *Conn) Send(cmd string) (Response, error) {
 	if err := c.Write(cmd); err != nil {
-		return Response{}, fmt.Errorf("send: %w", err)
+		return Response{}, err // already wrapped with ErrWrite
 	}
 
 	resp, err := c.Read()
@@ -164,13 +378,99 @@ func (c *Conn) SendCtx(ctx context.Context, cmd string) (Response, error) {
 	return resp, nil
 }
 
+// ExecCtx sends the commands with a single flush and reads the frames until
+// the reply to every command is received, with the context and command timeout.
+//
+// The commands may start the event stream, so the other frames read before
+// the last reply, like the events, are passed to the other function in order.
+func (c *Conn) ExecCtx(ctx context.Context, cmds []string, other func(Response)) ([]Response, error) {
+	var count int // the empty commands are not sent
+
+	for _, cmd := range cmds {
+		if cmd != "" {
+			count++
+		}
+	}
+
+	replies := make([]Response, 0, count)
+
+	if err := c.withTimeout(ctx, func() error {
+		if err := c.WriteBatch(cmds, nil); err != nil {
+			return err // already wrapped with ErrWrite
+		}
+
+		for len(replies) < count {
+			resp, err := c.Read()
+			if err != nil {
+				return fmt.Errorf("read: %w", err)
+			}
+
+			switch resp.ContentType {
+			case ctCommandReply, ctAPIResponse:
+				replies = append(replies, resp)
+			default:
+				other(resp)
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return replies, err
+	}
+
+	return replies, nil
+}
+
 // withTimeout executes the given function with a timeout context.
 //
 // If the timeout is reached, it returns ErrTimeout.
 // If the context is canceled, it returns context.Cause(ctx).
 // If the function returns an error, it returns the error.
 // Otherwise, it returns nil.
+//
+// If the connection supports the read deadline, the function is executed
+// in the current goroutine and interrupted by the deadline.
+// Otherwise, it's executed in a separate goroutine, which is left blocked
+// until the next read or close on timeout.
 func (c *Conn) withTimeout(ctx context.Context, f func() error) error {
+	if c.cmdTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, c.cmdTimeout, ErrTimeout)
+		defer cancel()
+	}
+
+	if c.deadliner == nil {
+		return withTimeoutAsync(ctx, f)
+	}
+
+	deadline, _ := ctx.Deadline() // zero time means no deadline
+	if err := c.SetReadDeadline(deadline); err != nil {
+		return err
+	}
+
+	defer c.SetReadDeadline(time.Time{}) //nolint:errcheck
+
+	// interrupt the blocked read when the context is canceled
+	stop := context.AfterFunc(ctx, func() {
+		_ = c.deadliner.SetReadDeadline(time.Unix(1, 0)) // in the past
+	})
+	defer stop()
+
+	err := f()
+	if err != nil && (ctx.Err() != nil || errors.Is(err, os.ErrDeadlineExceeded)) {
+		// the deadline is set only from the context
+		<-ctx.Done()
+
+		//nolint:wrapcheck // return the original context error
+		return context.Cause(ctx)
+	}
+
+	return err
+}
+
+// withTimeoutAsync executes the given function in a separate goroutine
+// and waits for its result or the context done.
+func withTimeoutAsync(ctx context.Context, f func() error) error {
 	errCh := make(chan error, 1)
 
 	go func() {
@@ -178,12 +478,6 @@ func (c *Conn) withTimeout(ctx context.Context, f func() error) error {
 		close(errCh)
 	}()
 
-	if c.cmdTimeout > 0 {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeoutCause(ctx, c.cmdTimeout, ErrTimeout)
-		defer cancel()
-	}
-
 	select {
 	case <-ctx.Done():
 		//nolint:wrapcheck // return the original context error
@@ -196,61 +490,107 @@ func (c *Conn) withTimeout(ctx context.Context, f func() error) error {
 // auth authenticates the connection using the provided password.
 //
 // It reads the server response, validates the content type, and sends the authentication request.
-// Returns an error if the request fails or the response is unexpected.
-func (c *Conn) auth(password string) error {
+// If the server replies "+OK" instead of the auth request, the connection
+// is already authorized and the password is not sent.
+// Returns the server reply to the authentication, or an error if the request
+// fails or the response is unexpected.
+func (c *Conn) auth(password string) (Response, error) {
 	resp, err := c.Read()
 	if err != nil {
-		return fmt.Errorf("read server request: %w", err)
+		return Response{}, fmt.Errorf("read server request: %w", err)
 	}
 
 	switch resp.ContentType {
 	default:
-		return fmt.Errorf("unexpected auth request content type: %s", resp.ContentType)
+		return Response{}, fmt.Errorf("unexpected auth request content type: %s", resp.ContentType)
 	case ctReject:
-		return ErrAccessDenied
+		return Response{}, ErrAccessDenied
 	case ctDisconnect:
-		return fmt.Errorf("server disconnect: %w", io.EOF)
+		return Response{}, fmt.Errorf("server disconnect: %w", io.EOF)
+	case ctCommandReply:
+		// some embedded setups accept the connection without authentication
+		if strings.HasPrefix(resp.Text, "+OK") {
+			return resp, nil // already authorized
+		}
+
+		return Response{}, fmt.Errorf("unexpected auth request reply: %q", resp.Text)
 	case ctAuth: // OK
 	}
 
 	switch resp, err := c.Send("auth " + password); {
 	case err != nil:
-		return fmt.Errorf("read auth response: %w", err)
+		return Response{}, fmt.Errorf("auth: %w", err)
 	case resp.ContentType != ctCommandReply:
-		return fmt.Errorf("unexpected auth response content type: %s", resp.ContentType)
+		return Response{}, fmt.Errorf("unexpected auth response content type: %s", resp.ContentType)
 	case !strings.HasPrefix(resp.Text, "+OK"):
-		return ErrInvalidPassword
+		return Response{}, ErrInvalidPassword
 	default:
-		return nil // OK
+		return resp, nil // OK
 	}
 }
 
-// readLine reads a line from the conn's reader.
+// readLine reads a line from the conn's reader without the line end.
+//
+// The read deadline bounds every read of the line fragments, so the line
+// stalled in the middle returns the deadline error rather than the partial line.
+// The last line fragment before EOF without the line end returns
+// io.ErrUnexpectedEOF, as the connection is closed in the middle of the line.
+//
+// Returns ErrLineTooLong if the line exceeds the maximum line length.
 func (c *Conn) readLine() ([]byte, error) {
 	var fullLine []byte // to accumulate full line
 
 	for {
-		line, more, err := c.r.ReadLine()
-		if err != nil {
+		line, err := c.r.ReadSlice('\n')
+		switch {
+		case err == nil: // it's the end of line
+		case errors.Is(err, bufio.ErrBufferFull):
+			if len(fullLine)+len(line) > c.maxLine {
+				return nil, ErrLineTooLong
+			}
+
+			fullLine = append(fullLine, line...) // accumulate
+
+			continue
+		case errors.Is(err, io.EOF) && len(fullLine)+len(line) > 0:
+			return nil, io.ErrUnexpectedEOF // not a malformed line
+		default:
 			return nil, err //nolint:wrapcheck
 		}
 
-		if fullLine == nil && !more {
-			return line, nil // the whole line is read at once
+		if fullLine != nil {
+			line = append(fullLine, line...)
 		}
 
-		fullLine = append(fullLine, line...) // accumulate
+		line = bytes.TrimSuffix(line, []byte{'\n'})
+		line = bytes.TrimSuffix(line, []byte{'\r'})
 
-		if !more {
-			return fullLine, nil // it's the end of line
+		if len(line) > c.maxLine {
+			return nil, ErrLineTooLong
 		}
+
+		return line, nil
 	}
 }
 
-// trimLeft removes leading spaces and tabs from the given byte slice and returns the result as a string.
+// mediaType returns the content type without the parameters,
+// like "text/event-plain" for "text/event-plain; charset=UTF-8".
+func mediaType(value string) string {
+	if i := strings.IndexByte(value, ';'); i >= 0 {
+		value = value[:i]
+	}
+
+	return strings.TrimSpace(value)
+}
+
+// trimLeft removes leading spaces, tabs and carriage returns from the given byte slice
+// and returns the result as a string.
+//
+// Only ASCII whitespace is removed, as the ESL header values are not encoded
+// at this level: the URL-decoded values of the events are trimmed by the event parser.
 func trimLeft(b []byte) string {
 	for i := range len(b) {
-		if b[i] != ' ' && b[i] != '\t' {
+		if b[i] != ' ' && b[i] != '\t' && b[i] != '\r' {
 			return string(b[i:])
 		}
 	}
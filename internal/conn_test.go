@@ -0,0 +1,394 @@
+package esl
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTrimLeft(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"", ""},
+		{"value", "value"},
+		{" value", "value"},
+		{"   value", "value"},
+		{"\t\tvalue", "value"},
+		{" \t \tvalue", "value"},
+		{"\r value", "value"},
+		{"value ", "value "},
+		{" \t ", ""},
+	}
+
+	for _, tt := range tests {
+		if got := trimLeft([]byte(tt.value)); got != tt.want {
+			t.Errorf("trimLeft(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+// newTestConn returns a connection reading the given frames.
+func newTestConn(frames string, opts ...Option) *Conn {
+	rw := bufio.NewReadWriter(
+		bufio.NewReader(strings.NewReader(frames)),
+		bufio.NewWriter(io.Discard))
+	conn := &Conn{
+		r:          rw.Reader,
+		w:          rw.Writer,
+		mu:         sync.Mutex{},
+		cmdTimeout: 0,
+		deadliner:  nil,
+		maxLine:    DefaultMaxLineLength,
+		headers:    false,
+		decompress: false,
+		tee:        nil,
+	}
+
+	for _, opt := range opts {
+		opt(conn)
+	}
+
+	return conn
+}
+
+func TestConnRead(t *testing.T) {
+	conn := newTestConn("Content-Type: command/reply\nReply-Text: +OK accepted\n\n" +
+		"Content-Type: api/response\nContent-Length: 5\n\n+OK\n\n" +
+		"\n\nContent-Type: text/disconnect-notice\nJob-UUID: 123\n\n")
+
+	resp, err := conn.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.ContentType != ctCommandReply || resp.Text != "+OK accepted" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	resp, err = conn.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.ContentType != ctAPIResponse || resp.Body != "+OK\n\n" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	if got := string(resp.BodyBytes()); got != resp.Body {
+		t.Errorf("BodyBytes() = %q, want %q", got, resp.Body)
+	}
+
+	// the empty lines before the response are skipped
+	resp, err = conn.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.ContentType != ctDisconnect || resp.JobUUID != "123" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	if _, err = conn.Read(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestConnReadContentLengthSpaces(t *testing.T) {
+	resp, err := newTestConn("Content-Type: api/response\nContent-Length: 3 \t\n\n+OK").Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Body != "+OK" {
+		t.Errorf("unexpected body: %q", resp.Body)
+	}
+}
+
+func TestConnReadZeroContentLength(t *testing.T) {
+	conn := newTestConn("Content-Type: api/response\nContent-Length: 0\n\n" +
+		"Content-Type: command/reply\nReply-Text: +OK\n\n")
+
+	resp, err := conn.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.ContentType != ctAPIResponse || resp.Body != "" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	// the next frame must be parsed from its first byte
+	resp, err = conn.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.ContentType != ctCommandReply || resp.Text != "+OK" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+}
+
+func TestConnReadContentTypeParams(t *testing.T) {
+	const event = "Event-Name: HEARTBEAT\n\n"
+
+	conn := newTestConn("Content-Type: text/event-plain; charset=UTF-8\nContent-Length: " +
+		strconv.Itoa(len(event)) + "\n\n" + event)
+
+	resp, err := conn.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.ContentType != "text/event-plain" || resp.Body != event {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+}
+
+func TestConnReadErrors(t *testing.T) {
+	tests := []struct {
+		name, frame string
+	}{
+		{"malformed header", "Content-Type: command/reply\n: value\n\n"},
+		{"header without colon", "Content-Type\n\n"},
+		{"bad content-length", "Content-Type: api/response\nContent-Length: abc\n\n"},
+		{"negative content-length", "Content-Type: api/response\nContent-Length: -1\n\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := newTestConn(tt.frame).Read()
+			if err == nil {
+				t.Fatalf("expected error, got %+v", resp)
+			}
+
+			var protoErr *ProtocolError
+			if !errors.As(err, &protoErr) {
+				t.Errorf("expected protocol error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestConnReadTruncatedBody(t *testing.T) {
+	for _, frame := range []string{
+		"Content-Type: api/response\nContent-Length: 10\n\n+OK",
+		"Content-Type: api/response\nContent-Length: 10\n\n",
+	} {
+		_, err := newTestConn(frame).Read()
+		if !errors.Is(err, ErrTruncatedBody) || !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("expected truncated body error, got %v", err)
+		}
+	}
+}
+
+func TestConnReadUnterminated(t *testing.T) {
+	resp, err := newTestConn("Content-Type: command/reply\nReply-Text: +OK bye\n").Read()
+	if !errors.Is(err, ErrUnterminated) || !errors.Is(err, io.EOF) {
+		t.Fatalf("expected unterminated error, got %v", err)
+	}
+
+	if resp.ContentType != ctCommandReply || resp.Text != "+OK bye" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	_, err = newTestConn("Content-Type: api/response\nContent-Length: 10\n").Read()
+	if !errors.Is(err, ErrTruncatedBody) {
+		t.Errorf("expected truncated body error, got %v", err)
+	}
+
+	// the connection is closed in the middle of the header line
+	_, err = newTestConn("Content-Type: command/reply\nContent-Ty").Read()
+
+	var protoErr *ProtocolError
+	if !errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &protoErr) {
+		t.Errorf("expected unexpected EOF, got %v", err)
+	}
+}
+
+func TestConnReadDecompression(t *testing.T) {
+	var buf bytes.Buffer
+
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("+OK compressed")) //nolint:errcheck
+	zw.Close()
+
+	frame := "Content-Type: api/response\nContent-Encoding: gzip\nContent-Length: " +
+		strconv.Itoa(buf.Len()) + "\n\n" + buf.String()
+
+	resp, err := newTestConn(frame, WithDecompression()).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Body != "+OK compressed" {
+		t.Errorf("unexpected body: %q", resp.Body)
+	}
+
+	// the body is kept as is without the option
+	if resp, err = newTestConn(frame).Read(); err != nil || resp.Body != buf.String() {
+		t.Errorf("unexpected response: %+v, %v", resp, err)
+	}
+
+	var protoErr *ProtocolError
+
+	_, err = newTestConn("Content-Type: api/response\nContent-Encoding: gzip\nContent-Length: 3\n\n+OK",
+		WithDecompression()).Read()
+	if !errors.As(err, &protoErr) {
+		t.Errorf("expected protocol error, got %v", err)
+	}
+}
+
+func TestConnReadPartialLineTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		// the header line is never completed and must not be parsed as malformed
+		server.Write([]byte("Content-Type")) //nolint:errcheck
+	}()
+
+	conn := &Conn{
+		r:          bufio.NewReader(client),
+		w:          bufio.NewWriter(client),
+		mu:         sync.Mutex{},
+		cmdTimeout: 0,
+		deadliner:  client,
+		maxLine:    DefaultMaxLineLength,
+		headers:    false,
+		decompress: false,
+		tee:        nil,
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := conn.Read()
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected deadline error, got %+v, %v", resp, err)
+	}
+}
+
+func TestConnReadLine(t *testing.T) {
+	const bufSize = 4096 // the default bufio buffer size
+
+	long := strings.Repeat("x", bufSize)
+
+	tests := []struct {
+		name, data string
+		want       []string
+	}{
+		{"empty lines", "\n\na\n\n", []string{"", "", "a", ""}},
+		{"crlf", "a\r\n\r\nb\n", []string{"a", "", "b"}},
+		{"buffer size", long + "\n" + long[1:] + "\n", []string{long, long[1:]}},
+		{"crlf on buffer boundary", long[1:] + "\r\n\r\n", []string{long[1:], ""}},
+		{"empty fragment", long + "\n\n", []string{long, ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newTestConn(tt.data)
+
+			for i, want := range tt.want {
+				line, err := conn.readLine()
+				if err != nil {
+					t.Fatalf("line %d: %v", i, err)
+				}
+
+				if string(line) != want {
+					t.Errorf("line %d length = %d, want %d", i, len(line), len(want))
+				}
+			}
+
+			if _, err := conn.readLine(); !errors.Is(err, io.EOF) {
+				t.Errorf("expected EOF, got %v", err)
+			}
+		})
+	}
+}
+
+func TestConnReadCRLF(t *testing.T) {
+	resp, err := newTestConn("\r\nContent-Type: api/response\r\nContent-Length: 3\r\n\r\n+OK").Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.ContentType != ctAPIResponse || resp.Body != "+OK" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+}
+
+func TestConnReadLongLine(t *testing.T) {
+	const size = 10000 // longer than the default bufio buffer size
+
+	value := strings.Repeat("x", size)
+	conn := newTestConn("Content-Type: command/reply\nReply-Text: " + value + "\n\n")
+
+	resp, err := conn.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Text != value {
+		t.Errorf("unexpected reply text length: %d", len(resp.Text))
+	}
+
+	conn = newTestConn("Content-Type: command/reply\nReply-Text: "+value+"\n\n",
+		WithMaxLineLength(size))
+	if _, err = conn.Read(); !errors.Is(err, ErrLineTooLong) {
+		t.Errorf("expected ErrLineTooLong, got %v", err)
+	}
+}
+
+func TestConnWriteBatch(t *testing.T) {
+	var buf bytes.Buffer
+
+	conn := &Conn{
+		r:          nil,
+		w:          bufio.NewWriter(&buf),
+		mu:         sync.Mutex{},
+		cmdTimeout: 0,
+		deadliner:  nil,
+		maxLine:    DefaultMaxLineLength,
+		headers:    false,
+		decompress: false,
+		tee:        nil,
+	}
+
+	errQueue := errors.New("queue")
+	if err := conn.WriteBatch([]string{"api status"}, func() error { return errQueue }); !errors.Is(err, errQueue) {
+		t.Errorf("expected the queue error, got %v", err)
+	}
+
+	if buf.Len() != 0 {
+		t.Errorf("written after the queue error: %q", buf.String())
+	}
+
+	var queued bool
+	if err := conn.WriteBatch([]string{"event plain ALL", "", "api status"}, func() error {
+		queued = buf.Len() == 0 // before anything is sent
+
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !queued {
+		t.Error("the queue function is called after the write")
+	}
+
+	if got, want := buf.String(), "event plain ALL\n\napi status\n\n"; got != want {
+		t.Errorf("written %q, want %q", got, want)
+	}
+}
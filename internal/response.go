@@ -21,10 +21,26 @@ const (
 
 // Response represents an ESL Response with headers and a body.
 type Response struct {
-	ContentType string // Content-Type
-	Text        string // Reply-Text
-	JobUUID     string // Job-UUID
-	Body        string // Body
+	ContentType string            // Content-Type
+	Text        string            // Reply-Text
+	JobUUID     string            // Job-UUID
+	Body        string            // Body
+	Headers     map[string]string // all headers, set only with WithHeaders option
+
+	body []byte // the body as read from the connection
+}
+
+// BodyBytes returns the body as a byte slice.
+//
+// It's the slice read from the connection without copying, shared by all
+// receivers of the response, so it must not be modified.
+// The body of the response created without reading is copied.
+func (r Response) BodyBytes() []byte {
+	if r.body == nil && r.Body != "" {
+		return []byte(r.Body)
+	}
+
+	return r.body
 }
 
 // AsErr checks the content type of the response and returns an error if it matches a specific case.
@@ -2,12 +2,21 @@ package esl
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"maps"
+	"math/rand/v2"
 	"net"
+	"net/url"
+	"os"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	esl "github.com/mdigger/eslmon/internal"
@@ -16,37 +25,171 @@ import (
 // Monitor errors.
 var (
 	ErrNotConnected    = errors.New("not connected")
-	ErrAccessDenied    = errors.New("access denied")
-	ErrInvalidPassword = errors.New("invalid password")
-	ErrTimeout         = errors.New("timeout")
+	ErrAccessDenied    = esl.ErrAccessDenied
+	ErrInvalidPassword = esl.ErrInvalidPassword
+	ErrTimeout         = esl.ErrTimeout
+	ErrNilChannel      = errors.New("send channel cannot be nil")
+	ErrLineTooLong     = esl.ErrLineTooLong
+	ErrTruncatedBody   = esl.ErrTruncatedBody
+	ErrUnterminated    = esl.ErrUnterminated
+	ErrWrite           = esl.ErrWrite
+	ErrContentType     = errors.New("unexpected content type")
+	ErrUnknownEvent    = errors.New("unknown event name")
+	ErrInvalidArgument = errors.New("invalid command argument")
 )
 
+// ProtocolError is the error of parsing the frame or event sent by the server.
+// Use errors.As to distinguish it from the network errors.
+type ProtocolError = esl.ProtocolError
+
+// defaultPort is the default ESL server port.
+const defaultPort = "8021"
+
 // Monitor represents a FreeSWITCH ESL Monitor instance.
 type Monitor struct {
+	network        string // "tcp" or "unix"
 	addr, password string
 	dialer         *net.Dialer
-	subscribers    []subscriber
+	tls            *tls.Config     // TLS configuration, nil for plaintext
+	subsMu         sync.RWMutex    // protects the subscribers slice
+	subscribers    []*subscriber   // copied on write
+	subsIndex      subscriberIndex // subscribers by event name, rebuilt on write
+	applyMu        sync.Mutex      // to apply the subscriptions in order
+	rawSubscribers []chan<- Response
 	cmdTimeout     time.Duration
+	variables      map[string]struct{}                       // whitelisted channel variables, nil to keep all
+	recent         *eventRing                                // the most recent events, nil if disabled
+	firstTimeout   *time.Duration                            // timeout for the first event after subscribe, nil for the default
+	staleTimeout   time.Duration                             // health staleness window, zero to disable
+	staleBefore    time.Duration                             // drop events older than this, zero to disable
+	source         string                                    // source tag added to the events
+	tags           map[string]string                         // static headers added to the events
+	eventLog       *eventLog                                 // JSON lines event log, nil if disabled
+	name           string                                    // monitor name for logging
+	logger         *slog.Logger                              // logger set by user
+	log            *slog.Logger                              // logger with the monitor name
+	linger         int                                       // SO_LINGER seconds, negative for the system default
+	noDelay        *bool                                     // TCP_NODELAY, nil for the Go default (enabled)
+	maxLine        int                                       // maximum header line length
+	heartbeat      time.Duration                             // heartbeat timeout, zero to disable
+	reconnect      func(error) bool                          // reconnect policy, nil to disable reconnects
+	delayFunc      func(int, error) time.Duration            // delay before the reconnect
+	transform      func(Event) Event                         // event transform before the dispatch, nil to disable
+	passwordFunc   func(ctx context.Context) (string, error) // password getter, nil to use password
+	handlers       []func(Event) error                       // event handlers to stop the monitor
+	reconcile      func(context.Context, []Channel)          // active channels callback after connect
+	unknownMode    UnknownContentType                        // unknown content types handling
+	contentTypes   map[string]struct{}                       // dispatched content types, nil for all
+	rawSubscribe   string                                    // subscribe command override
+	applied        SubscriptionPlan                          // the server subscription of the connection, guarded by applyMu
+	resubscribe    bool                                      // the applied subscription is replaced by myevents, guarded by applyMu
+	cmdRecorder    io.Writer                                 // copy of the sent commands for tests
+	closeOnExit    bool                                      // close the subscriber channels when Run returns
+	strictNames    bool                                      // validate the subscribed event names
+	decompress     bool                                      // decompress the gzip encoded bodies
+	drainTimeout   time.Duration                             // delivery grace period on shutdown
+	parser         eventParser                               // plain events parser
+
+	// used only by the read loop
+	dedup        *sequenceDedup // duplicates detection, nil if disabled
+	maxSequence  int64          // the last accepted event sequence
+	maxTimestamp time.Time      // the last accepted event timestamp
+	gaps         bool           // detect the event sequence gaps
+	lastSeq      int64          // the last received event sequence
+
+	stats     stats         // statistics
+	watchers  watchers      // event watchers
+	ready     chan struct{} // closed after the first successful subscription
+	readyOnce sync.Once     // to close the ready channel once
+	errc      chan error    // the Run exit error, buffered
+	connected atomic.Bool   // the connection is established and subscribed
+	lastRead  atomic.Int64  // the last frame read time in unix nanoseconds
+	seenSeq   atomic.Int64  // the highest dispatched event sequence
+	paused    atomic.Bool   // the delivery to the subscribers is disabled
+
+	mu        sync.Mutex          // protects the connection state
+	conn      *esl.Conn           // active connection, nil if not connected
+	netConn   net.Conn            // active network connection, nil if not connected
+	pending   []chan esl.Response // waiting for the command replies
+	authReply esl.Response        // the last server reply to the authentication
+	cmdSem    chan struct{}       // concurrent commands semaphore, nil if unlimited
 }
 
 // New creates a new FreeSWITCH ESL Monitor instance.
 //
 // If the address doesn't contain a port, use the default port (8021).
+// The address starting with "/" or "unix://" is a Unix domain socket path.
 // Panic if the address is malformed.
 func New(addr, password string) *Monitor {
 	const (
-		dialTimeout         = time.Second * 5 // dialer timeout
-		cmdTimeout          = time.Second * 5 // command timeout
-		subscribersCapacity = 10              // capacity for the subscribers slice
+		dialTimeout = time.Second * 5 // dialer timeout
+		cmdTimeout  = time.Second * 5 // command timeout
 	)
 
-	return &Monitor{
-		addr:        addAddrPort(addr),
-		password:    password,
-		dialer:      &net.Dialer{Timeout: dialTimeout}, //nolint:exhaustruct
-		subscribers: make([]subscriber, 0, subscribersCapacity),
-		cmdTimeout:  cmdTimeout,
+	network := "tcp"
+	if path, ok := unixSocketPath(addr); ok {
+		network, addr = "unix", path
+	} else {
+		addr = addAddrPort(addr)
+	}
+
+	m := &Monitor{
+		network:    network,
+		addr:       addr,
+		password:   password,
+		dialer:     &net.Dialer{Timeout: dialTimeout}, //nolint:exhaustruct
+		cmdTimeout: cmdTimeout,
+		name:       fmt.Sprintf("%s#%04x", addr, rand.N(0x10000)), //nolint:gosec,mnd
+		logger:     slog.Default(),
+		linger:     -1,
+		delayFunc:  DefaultReconnectDelay,
+		ready:      make(chan struct{}),
+		errc:       make(chan error, 1),
+	}
+	m.log = m.logger.With(slog.String("monitor", m.name))
+
+	return m
+}
+
+// NewFromURL creates a new FreeSWITCH ESL Monitor instance from the connection URL.
+//
+// The "esl" scheme is used for plaintext connections and "esls" for TLS:
+//
+//	esl://127.0.0.1:8021
+//	esls://:ClueCon@fs.example.com
+//
+// The password from the URL userinfo, if present, overrides the password parameter.
+// If the address doesn't contain a port, use the default port (8021).
+func NewFromURL(raw, password string) (*Monitor, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("bad url: %w", err)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("bad url: missing host in %q", raw)
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+
+	if pass, ok := u.User.Password(); ok {
+		password = pass
+	}
+
+	m := New(addr, password)
+
+	switch u.Scheme {
+	case "esl": // plaintext
+	case "esls":
+		m.WithTLS(&tls.Config{}) //nolint:exhaustruct,gosec // the server name is set by dialer
+	default:
+		return nil, fmt.Errorf("bad url: unsupported scheme %q", u.Scheme)
 	}
+
+	return m, nil
 }
 
 // Subscribe adds a new subscriber to the Monitor.
@@ -55,8 +198,80 @@ func New(addr, password string) *Monitor {
 //
 // The events parameter is a list of event names.
 // If no events are provided or the "*" wildcard is used, all events are subscribed.
+//
+// Subscribe panics if the send channel is nil, or an event name is unknown
+// with WithStrictEventNames. Use SubscribeErr to get an error instead.
 func (m *Monitor) Subscribe(send chan<- Event, events ...string) *Monitor {
-	m.subscribers = append(m.subscribers, newSubscriber(send, events...))
+	return m.SubscribeWith(send, DeliveryBlocking, events...)
+}
+
+// SubscribeErr adds a new subscriber to the Monitor like Subscribe,
+// but returns ErrNilChannel instead of panic if the send channel is nil,
+// and ErrUnknownEvent if an event name is unknown with WithStrictEventNames.
+func (m *Monitor) SubscribeErr(send chan<- Event, events ...string) error {
+	if send == nil {
+		return ErrNilChannel
+	}
+
+	if err := m.checkEventNames(events); err != nil {
+		return err
+	}
+
+	m.addSubscriber(newSubscriber(send, events...))
+
+	return nil
+}
+
+// WithStrictEventNames enables the validation of the event names on subscribe.
+// It must be called before the subscribers are added.
+//
+// By default, the unknown names are subscribed as the CUSTOM event subclasses,
+// so a typo, like "CHANNEL_HANGUP_COMPLET", silently gives no events.
+// In the strict mode, the name must be a known event name, a subclass with
+// the "CUSTOM " prefix, or a subclass in the "module::event" form, like
+// "sofia::register". Use RegisterEventName to add the new event names.
+func (m *Monitor) WithStrictEventNames() *Monitor {
+	m.strictNames = true
+
+	return m
+}
+
+// checkEventNames returns ErrUnknownEvent if an event name is unknown
+// in the strict mode.
+func (m *Monitor) checkEventNames(events []string) error {
+	if !m.strictNames || eventNamesSet(events) == nil {
+		return nil // all events
+	}
+
+	for _, name := range events {
+		if strings.HasPrefix(name, "CUSTOM ") || strings.Contains(name, "::") || isEventName(name) {
+			continue
+		}
+
+		return fmt.Errorf("%w: %q", ErrUnknownEvent, name)
+	}
+
+	return nil
+}
+
+// Response represents a raw ESL frame with headers and a body.
+type Response = esl.Response
+
+// SubscribeRaw adds a new subscriber for the raw frames.
+//
+// Every frame read after the subscription, including the command replies,
+// API responses and disconnect notices, is sent to the channel with all headers.
+// It's intended for debugging the protocol issues. A full channel blocks
+// the read loop, like the event subscribers, until the drain timeout on exit.
+//
+// SubscribeRaw panics if the send channel is nil.
+func (m *Monitor) SubscribeRaw(send chan<- Response) *Monitor {
+	if send == nil {
+		//nolint:forbidigo // the same as Subscribe
+		panic(ErrNilChannel)
+	}
+
+	m.rawSubscribers = append(m.rawSubscribers, send)
 
 	return m
 }
@@ -67,137 +282,1449 @@ func (m *Monitor) Subscribe(send chan<- Event, events ...string) *Monitor {
 // The error is the context error.
 //
 // Returns an error if the connection fails or the authentication fails.
-func (m *Monitor) Run(ctx context.Context) error {
-	conn, err := m.dialer.DialContext(ctx, "tcp", m.addr)
+// If the reconnect policy is set with WithReconnectPolicy, the failed connection
+// is retried while the policy allows it.
+//
+// Without subscribers, Run doesn't subscribe to the events and keeps
+// the connection for the commands only. The unsolicited events are read
+// and discarded.
+func (m *Monitor) Run(ctx context.Context) (err error) {
+	if m.closeOnExit {
+		defer m.closeSubscribers()
+	}
+
+	// the error is sent before the subscriber channels are closed
+	defer func() {
+		select {
+		case m.errc <- err:
+		default: // the previous error is not received yet
+		}
+	}()
+
+	drained, stop := m.drainDeadline(ctx)
+	defer stop()
+
+	if m.eventLog != nil {
+		defer m.eventLog.start(m.log)()
+	}
+
+	for attempt := 0; ; attempt++ {
+		var connected bool
+		connected, err = m.run(ctx, drained)
+
+		var stopErr *stopError
+		if ctx.Err() != nil || errors.As(err, &stopErr) || m.reconnect == nil || !m.reconnect(err) {
+			return err
+		}
+
+		if connected {
+			attempt = 0 // the connection was established, start over
+		}
+
+		delay := m.delayFunc(attempt, err)
+		m.log.Warn("esl: reconnect", slog.String("error", err.Error()), slog.Duration("delay", delay))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return fmt.Errorf("done: %w", context.Cause(ctx))
+		case <-timer.C:
+		}
+	}
+}
+
+// run connects to the ESL server, subscribes to the events and reads them
+// until the error or the context is done.
+//
+// The drained channel is closed when the blocked delivery to the subscribers
+// should be abandoned.
+//
+// Returns true if the connection was established and subscribed.
+func (m *Monitor) run(ctx context.Context, drained <-chan struct{}) (bool, error) {
+	conn, err := m.dial(ctx)
 	if err != nil {
-		return fmt.Errorf("dialer: %w", err)
+		return false, fmt.Errorf("dialer: %w", err)
 	}
 
 	// disconnect after the context is done or exit with error
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	// the commands are flushed as they are sent, so nothing is left buffered,
+	// the unsent data in the socket is handled by the linger option
 	context.AfterFunc(ctx, func() { conn.Close() })
 
+	if m.linger >= 0 {
+		if err := setLinger(conn, m.linger); err != nil {
+			conn.Close()
+
+			return false, err
+		}
+	}
+
+	if m.noDelay != nil {
+		if err := setNoDelay(conn, *m.noDelay); err != nil {
+			conn.Close()
+
+			return false, err
+		}
+	}
+
+	password, err := m.getPassword(ctx)
+	if err != nil {
+		return false, err
+	}
+
 	// init ESL connection and authenticate
-	eslConn, err := esl.NewConn(ctx, conn, m.password, m.cmdTimeout)
+	eslConn, authReply, err := esl.NewConn(ctx, conn, password, m.cmdTimeout, m.connOptions()...)
 	if err != nil {
-		return fmt.Errorf("authenticate: %w", err)
+		return false, fmt.Errorf("authenticate: %w", err)
 	}
 
-	// subscribe to the ESL events if subscribers are set
-	if len(m.subscribers) > 0 {
-		resp, err := eslConn.SendCtx(ctx, m.subscribe())
-		if err != nil {
-			return fmt.Errorf("subscribe: %w", err)
-		}
+	m.mu.Lock()
+	m.authReply = authReply
+	m.mu.Unlock()
 
-		if err = resp.AsErr(); err != nil {
-			return fmt.Errorf("subscribe response: %w", err)
+	subscribed, early, err := m.initSubscriptions(ctx, eslConn)
+	if err != nil {
+		return false, err
+	}
+
+	// allow commands to be sent through the active connection
+	m.setConn(eslConn, conn)
+	defer m.setConn(nil, nil)
+
+	m.lastRead.Store(time.Now().UnixNano())
+	m.connected.Store(true)
+	defer m.connected.Store(false)
+	m.readyOnce.Do(func() { close(m.ready) })
+
+	// the subscribers changed while subscribing were skipped as disconnected
+	if m.subscriptionsChanged() {
+		m.autoApplySubscriptions()
+	}
+
+	if m.reconcile != nil {
+		go m.reconcileChannels(ctx)
+	}
+
+	// the events received while subscribing
+	for _, resp := range early {
+		if err := m.handleResponse(resp, drained); err != nil {
+			return true, err
 		}
 	}
 
+	// guard the first read to detect a server that went silent after subscribe:
+	// the idle server is probed with a command, its reply is the first frame
+	firstTimeout := m.firstEventTimeout()
+	firstRead := subscribed && len(early) == 0 && firstTimeout > 0
+
+	if firstRead {
+		since := m.lastRead.Load()
+		probe := time.AfterFunc(firstTimeout, func() { m.probeIdle(ctx, since) })
+		defer probe.Stop()
+
+		firstTimeout += m.cmdTimeout
+	}
+
 	for {
+		timeout := m.heartbeat
+		if firstRead {
+			timeout = firstTimeout
+		}
+
+		if err := m.setReadDeadline(eslConn, timeout); err != nil {
+			return true, err
+		}
+
 		resp, err := eslConn.Read()
 		if err != nil {
 			if err := context.Cause(ctx); err != nil {
-				return fmt.Errorf("done: %w", err) // context error
+				return true, fmt.Errorf("done: %w", err) // context error
 			}
 
-			return fmt.Errorf("read: %w", err) // read error
-		}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				if firstRead {
+					return true, fmt.Errorf("first event: %w", ErrTimeout)
+				}
 
-		switch resp.ContentType {
-		case "text/event-plain":
-			event, err := parseEvent(resp.Body)
-			if err != nil {
-				return fmt.Errorf("event parse: %w", err)
+				return true, fmt.Errorf("heartbeat: %w", ErrTimeout)
 			}
 
-			for _, subscriber := range m.subscribers {
-				subscriber.Handle(event)
+			if errors.Is(err, esl.ErrUnterminated) {
+				// the last response may be complete, handle it before exit
+				if err := m.handleResponse(resp, drained); err != nil {
+					return true, err
+				}
 			}
 
-		case "text/disconnect-notice":
-			return fmt.Errorf("server closed: %w", io.EOF)
+			return true, fmt.Errorf("read: %w", err) // read error
+		}
+
+		m.lastRead.Store(time.Now().UnixNano())
+		firstRead = false
+
+		if err := m.handleResponse(resp, drained); err != nil {
+			return true, err
 		}
 	}
 }
 
-// WithDialTimeout sets the dialer timeout.
-func (m *Monitor) WithDialTimeout(timeout time.Duration) *Monitor {
-	m.dialer.Timeout = timeout
+// handleResponse sends the response read from the connection
+// to the raw subscribers and dispatches it by the content type.
+func (m *Monitor) handleResponse(resp esl.Response, drained <-chan struct{}) error {
+	for _, send := range m.rawSubscribers {
+		select {
+		case send <- resp:
+		case <-drained: // never if the drain timeout is not set
+		}
+	}
+
+	if _, ok := m.contentTypes[resp.ContentType]; m.contentTypes != nil && !ok {
+		m.stats.filtered.Add(1)
+
+		return nil
+	}
+
+	switch resp.ContentType {
+	case "text/event-plain":
+		event, err := m.parser.Parse(resp.Body)
+		if err != nil {
+			return fmt.Errorf("event parse: %w", err)
+		}
+
+		return m.dispatch(event, drained)
+
+	case "command/reply", "api/response":
+		m.reply(resp)
+
+	case "text/disconnect-notice":
+		return fmt.Errorf("server closed: %w", io.EOF)
+
+	default:
+		return m.unknownContentType(resp)
+	}
+
+	return nil
+}
+
+// connOptions returns the ESL connection options.
+func (m *Monitor) connOptions() []esl.Option {
+	opts := []esl.Option{esl.WithMaxLineLength(m.maxLine)}
+
+	if len(m.rawSubscribers) > 0 {
+		opts = append(opts, esl.WithHeaders())
+	}
+
+	if m.decompress {
+		opts = append(opts, esl.WithDecompression())
+	}
+
+	if m.cmdRecorder != nil {
+		opts = append(opts, esl.WithWriteTee(m.cmdRecorder))
+	}
+
+	return opts
+}
+
+// withCommandRecorder writes a copy of all commands sent to the server to w,
+// including the authentication. It's used by the tests to check the exact
+// commands sent by Run, so w must be safe for concurrent use with the test.
+func (m *Monitor) withCommandRecorder(w io.Writer) *Monitor {
+	m.cmdRecorder = w
 
 	return m
 }
 
-// WithCommandsTimeout sets the command timeout.
-// The default command timeout is 5 seconds.
+// dial connects to the ESL server using TLS if it's configured.
+func (m *Monitor) dial(ctx context.Context) (net.Conn, error) {
+	if m.tls == nil {
+		return m.dialer.DialContext(ctx, m.network, m.addr) //nolint:wrapcheck
+	}
+
+	dialer := &tls.Dialer{NetDialer: m.dialer, Config: m.tls}
+
+	return dialer.DialContext(ctx, m.network, m.addr) //nolint:wrapcheck
+}
+
+// Ready returns a channel that is closed once Run has successfully connected,
+// authenticated and subscribed to the events.
 //
-// Used on authorization and subscription requests.
-func (m *Monitor) WithCommandsTimeout(timeout time.Duration) *Monitor {
-	m.cmdTimeout = timeout
+// The channel is closed only once and stays closed after the connection is lost,
+// use Healthy to check the current connection state.
+func (m *Monitor) Ready() <-chan struct{} {
+	return m.ready
+}
+
+// SeenSequence returns the highest Event-Sequence of the dispatched events,
+// or zero if no events were dispatched.
+//
+// It helps to hand off between two monitors of the same server without a gap:
+// the new monitor is started, and the old one is stopped once the SeenSequence
+// of the new one reaches the last sequence seen by the old one.
+// The value is not reset if the server restarts and starts the sequence over.
+func (m *Monitor) SeenSequence() int64 {
+	return m.seenSeq.Load()
+}
+
+// SetDispatchEnabled enables or disables the delivery of the events to the subscribers.
+// It's enabled by default and can be changed while the monitor is running.
+//
+// While disabled, the events are still read, counted and passed to the watchers
+// and OnEvent handlers, but not sent to the subscribers and counted in
+// Stats.Undelivered. It keeps the connection healthy during the downstream outage
+// without blocking on the subscriber channels.
+func (m *Monitor) SetDispatchEnabled(enabled bool) {
+	m.paused.Store(!enabled)
+}
+
+// RunBackground starts Run in a separate goroutine and returns the channel
+// receiving its error once Run has completely finished: the connection is closed,
+// the event log is written and the subscriber channels are closed if configured.
+// The channel is closed after the error.
+//
+// It's intended for embedding the monitor into a service, to wait for it before exit:
+//
+//	done := mon.RunBackground(ctx)
+//	// ...
+//	cancel()
+//	err := <-done
+func (m *Monitor) RunBackground(ctx context.Context) <-chan error {
+	done := make(chan error, 1)
+
+	go func() {
+		defer close(done)
+
+		done <- m.Run(ctx)
+	}()
+
+	return done
+}
+
+// ErrChannel returns a channel that receives the error Run returned with.
+//
+// It lets the subscribers distinguish the end of the stream from the absence of events:
+//
+//	select {
+//	case e := <-events:
+//		// process the event
+//	case err := <-mon.ErrChannel():
+//		// the monitor is stopped
+//	}
+//
+// The channel is buffered and keeps only the first unreceived error.
+func (m *Monitor) ErrChannel() <-chan error {
+	return m.errc
+}
+
+// AuthReply returns the server reply to the authentication of the last
+// connection, or the zero Response if the monitor hasn't connected yet.
+//
+// The reply text may contain the server details useful for the logs.
+func (m *Monitor) AuthReply() Response {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.authReply
+}
+
+// RemoteAddr returns the remote network address of the current connection,
+// or nil if the monitor is not connected.
+func (m *Monitor) RemoteAddr() net.Addr {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.netConn == nil {
+		return nil
+	}
+
+	return m.netConn.RemoteAddr()
+}
+
+// LocalAddr returns the local network address of the current connection,
+// or nil if the monitor is not connected.
+func (m *Monitor) LocalAddr() net.Addr {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.netConn == nil {
+		return nil
+	}
+
+	return m.netConn.LocalAddr()
+}
+
+// WithTLS enables the TLS connection with the given configuration.
+// A nil config disables TLS.
+//
+// If the config doesn't contain the ServerName, it's set from the address host.
+func (m *Monitor) WithTLS(config *tls.Config) *Monitor {
+	m.tls = config
 
 	return m
 }
 
-// subscribe returns the command string with ESL event names to subscribe.
-func (m *Monitor) subscribe() string {
-	const (
-		cmdSubscribe   = "event plain"
-		eventsCapacity = 100
-	)
+// WithLogger sets the logger. The default is slog.Default().
+// A nil logger disables logging.
+func (m *Monitor) WithLogger(logger *slog.Logger) *Monitor {
+	if logger == nil {
+		logger = slog.New(discardHandler{})
+	}
 
-	events := make(map[string]struct{}, eventsCapacity)
+	m.logger = logger
+	m.log = logger.With(slog.String("monitor", m.name))
 
-	for _, subscriber := range m.subscribers {
-		if len(subscriber.Names) == 0 {
-			return cmdSubscribe + " ALL" // all events should be handled
-		}
+	return m
+}
 
-		maps.Copy(events, subscriber.Names)
+// WithName sets the monitor name used in the logs.
+//
+// The default name is the address with a short random suffix,
+// which distinguishes several monitors of the same server.
+func (m *Monitor) WithName(name string) *Monitor {
+	m.name = name
+	m.log = m.logger.With(slog.String("monitor", name))
+
+	return m
+}
+
+// Name returns the monitor name used in the logs.
+func (m *Monitor) Name() string {
+	return m.name
+}
+
+// WithLinger sets the SO_LINGER option of the TCP connection in seconds.
+//
+// With a zero value, the unsent data is discarded and the connection is reset on close.
+// With a positive value, the close blocks in the background until the data is sent
+// or the timeout expires. A negative value (default) uses the system default behavior.
+func (m *Monitor) WithLinger(seconds int) *Monitor {
+	m.linger = seconds
+
+	return m
+}
+
+// setLinger sets the SO_LINGER option if the connection is a TCP connection.
+func setLinger(conn net.Conn, seconds int) error {
+	tcpConn, ok := asTCPConn(conn)
+	if !ok {
+		return nil // not supported
 	}
 
-	var cmd, custom strings.Builder
+	if err := tcpConn.SetLinger(seconds); err != nil {
+		return fmt.Errorf("set linger: %w", err)
+	}
 
-	cmd.WriteString(cmdSubscribe)
+	return nil
+}
 
-	for name := range events {
-		if _, ok := eventNames[name]; ok {
-			cmd.WriteByte(' ')
-			cmd.WriteString(name)
-		} else {
-			custom.WriteByte(' ')
-			custom.WriteString(name)
-		}
+// WithNoDelay sets the TCP_NODELAY option of the TCP connection.
+//
+// Go enables it by default, so the small commands are sent immediately,
+// which gives the lowest latency for the command and response patterns.
+// Disabling it lets the system batch the small writes (Nagle's algorithm)
+// for the higher throughput at the cost of the command latency.
+func (m *Monitor) WithNoDelay(noDelay bool) *Monitor {
+	m.noDelay = &noDelay
+
+	return m
+}
+
+// setNoDelay sets the TCP_NODELAY option if the connection is a TCP connection.
+func setNoDelay(conn net.Conn, noDelay bool) error {
+	tcpConn, ok := asTCPConn(conn)
+	if !ok {
+		return nil // not supported
 	}
 
-	if custom.Len() > 0 {
-		if cmd.Len() > 0 {
-			cmd.WriteByte(' ')
-		}
+	if err := tcpConn.SetNoDelay(noDelay); err != nil {
+		return fmt.Errorf("set no delay: %w", err)
+	}
 
-		cmd.WriteString("CUSTOM")
-		cmd.WriteString(custom.String())
+	return nil
+}
+
+// asTCPConn returns the underlying TCP connection, unwrapping TLS.
+func asTCPConn(conn net.Conn) (*net.TCPConn, bool) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
 	}
 
-	return cmd.String()
+	tcpConn, ok := conn.(*net.TCPConn)
+
+	return tcpConn, ok
 }
 
-// addAddrPort adds a default port to the given address if it doesn't contain a port.
-// If the address contains a port, it is returned as is.
-// Panics if the address is invalid.
-func addAddrPort(addr string) string {
-	// if the address doesn't contain a port, use the default port
-	if _, _, err := net.SplitHostPort(addr); err != nil {
-		var addrErr *net.AddrError
-		if !errors.As(err, &addrErr) || addrErr.Err != "missing port in address" {
-			//nolint:forbidigo // I don't want to return an error only for this
-			panic(fmt.Errorf("bad address: %w", err))
-		}
+// WithDecompression decompresses the frame bodies sent with
+// the "Content-Encoding: gzip" header.
+//
+// The core ESL doesn't compress the frames, but some high-volume setups
+// compress the large bodies. A body failed to decompress stops Run with
+// the ProtocolError.
+func (m *Monitor) WithDecompression() *Monitor {
+	m.decompress = true
 
-		const defaultPort = "8021"
-		addr = net.JoinHostPort(addr, defaultPort)
+	return m
+}
+
+// WithMaxLineLength sets the maximum length of the ESL header line.
+// The default is 4 MB.
+//
+// The connection is closed with an error if the server sends a longer line.
+func (m *Monitor) WithMaxLineLength(n int) *Monitor {
+	m.maxLine = n
+
+	return m
+}
+
+// WithClientCert enables the mutual TLS connection with the client certificate.
+//
+// The server certificate is verified with the caPool or with the system pool if it's nil.
+// The server name for verification is set from the host of the monitor address.
+func (m *Monitor) WithClientCert(cert tls.Certificate, caPool *x509.CertPool) *Monitor {
+	host, _, _ := net.SplitHostPort(m.addr) // the address is already validated
+
+	return m.WithTLS(&tls.Config{ //nolint:exhaustruct,gosec // minimal version is the default
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      caPool,
+		ServerName:   host,
+	})
+}
+
+// WithPasswordFunc sets the function to get the password on each connection.
+// A nil function restores the password given to New.
+//
+// It supports the rotating credentials for the long-lived monitors with reconnects.
+func (m *Monitor) WithPasswordFunc(fn func(ctx context.Context) (string, error)) *Monitor {
+	m.passwordFunc = fn
+
+	return m
+}
+
+// getPassword returns the password for the new connection.
+func (m *Monitor) getPassword(ctx context.Context) (string, error) {
+	if m.passwordFunc == nil {
+		return m.password, nil
 	}
 
-	return addr
+	password, err := m.passwordFunc(ctx)
+	if err != nil {
+		return "", fmt.Errorf("password: %w", err)
+	}
+
+	return password, nil
+}
+
+// UnknownContentType defines how the frames with unknown content types are handled.
+type UnknownContentType int
+
+// Unknown content types handling modes.
+const (
+	UnknownIgnore UnknownContentType = iota // ignore and log at the debug level
+	UnknownLog                              // ignore and log at the warning level
+	UnknownError                            // stop with ErrContentType
+)
+
+// WithUnknownContentType sets how the frames with unknown content types are handled.
+// The default is UnknownIgnore.
+func (m *Monitor) WithUnknownContentType(mode UnknownContentType) *Monitor {
+	m.unknownMode = mode
+
+	return m
+}
+
+// WithContentTypeFilter sets the content types of the frames handled by Run,
+// like "text/event-plain". The other frames are dropped and counted in
+// Stats.Filtered. If no content types are provided, all frames are handled.
+//
+// The frames are sent to the raw subscribers before the filter. Note that
+// the commands wait for the "command/reply" and "api/response" frames until
+// timeout if they are filtered, and the "text/disconnect-notice" frame is
+// needed to detect the server disconnect before the connection is closed.
+func (m *Monitor) WithContentTypeFilter(allow ...string) *Monitor {
+	m.contentTypes = nil
+	if len(allow) > 0 {
+		m.contentTypes = make(map[string]struct{}, len(allow))
+		for _, contentType := range allow {
+			m.contentTypes[contentType] = struct{}{}
+		}
+	}
+
+	return m
+}
+
+// unknownContentType handles the frame with the unknown content type.
+func (m *Monitor) unknownContentType(resp Response) error {
+	switch m.unknownMode {
+	case UnknownError:
+		return fmt.Errorf("%w: %q", ErrContentType, resp.ContentType)
+	case UnknownLog:
+		m.log.Warn("esl: unsupported response", slog.Any("response", resp))
+	default:
+		m.log.Debug("esl: unsupported response", slog.Any("response", resp))
+	}
+
+	return nil
+}
+
+// WithDialTimeout sets the dialer timeout.
+func (m *Monitor) WithDialTimeout(timeout time.Duration) *Monitor {
+	m.dialer.Timeout = timeout
+
+	return m
+}
+
+// WithResolver sets the DNS resolver used to resolve the server host name.
+// A nil resolver uses the default one.
+//
+// It's useful when the server is known only to the internal service
+// discovery DNS, which the default resolver can't see.
+func (m *Monitor) WithResolver(r *net.Resolver) *Monitor {
+	m.dialer.Resolver = r
+
+	return m
+}
+
+// WithCommandsTimeout sets the command timeout.
+// The default command timeout is 5 seconds.
+//
+// Used on authorization and subscription requests.
+func (m *Monitor) WithCommandsTimeout(timeout time.Duration) *Monitor {
+	m.cmdTimeout = timeout
+
+	return m
+}
+
+// WithFirstEventTimeout sets the timeout for the first frame after the subscription.
+// The default is the heartbeat timeout if it's set, otherwise the commands timeout.
+// Zero disables the check.
+//
+// It detects a server that accepted the subscription but then went silent.
+// If nothing is received within the timeout, the server is probed with the
+// "api status" command, so the idle server without the subscribed events
+// isn't reconnected. Run returns ErrTimeout if the reply isn't received
+// within the commands timeout.
+func (m *Monitor) WithFirstEventTimeout(timeout time.Duration) *Monitor {
+	m.firstTimeout = &timeout
+
+	return m
+}
+
+// firstEventTimeout returns the timeout for the first frame after the subscription.
+func (m *Monitor) firstEventTimeout() time.Duration {
+	switch {
+	case m.firstTimeout != nil:
+		return *m.firstTimeout
+	case m.heartbeat > 0:
+		return m.heartbeat
+	default:
+		return m.cmdTimeout
+	}
+}
+
+// probeIdle sends the command to the server if no frame was read since the
+// given time, to tell the idle server from the silent one by the reply.
+func (m *Monitor) probeIdle(ctx context.Context, since int64) {
+	if m.lastRead.Load() != since {
+		return // the frame is already received
+	}
+
+	if _, err := m.command(ctx, "api status"); err != nil {
+		m.log.Debug("esl: probe", slog.String("error", err.Error()))
+	}
+}
+
+// WithHeartbeatTimeout sets the maximum interval between the received frames.
+// The default is zero, which means no timeout.
+//
+// The HEARTBEAT events are added to the server subscription, but delivered only to
+// the subscribers that subscribed to them. Without such subscribers, they are also
+// skipped by the OnEvent handlers, the event log, RecentEvents, EventCounts and
+// SeenSequence. Run returns ErrTimeout if nothing is
+// received within the timeout, so it should be longer than the heartbeat interval
+// (20 seconds by default).
+func (m *Monitor) WithHeartbeatTimeout(timeout time.Duration) *Monitor {
+	m.heartbeat = timeout
+
+	return m
+}
+
+// setReadDeadline sets the read deadline for the next frame, zero timeout
+// means no deadline.
+func (m *Monitor) setReadDeadline(conn *esl.Conn, timeout time.Duration) error {
+	var deadline time.Time // zero time means no deadline
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	return conn.SetReadDeadline(deadline) //nolint:wrapcheck
+}
+
+// WithStaleTimeout sets the staleness window used by Healthy.
+// The default is zero, which means only the connection state is checked.
+//
+// Subscribe to the HEARTBEAT events and use a window longer than the heartbeat
+// interval (20 seconds by default) to detect a stalled connection.
+func (m *Monitor) WithStaleTimeout(timeout time.Duration) *Monitor {
+	m.staleTimeout = timeout
+
+	return m
+}
+
+// Healthy returns true if the monitor is connected and the last frame was
+// received within the staleness window set by WithStaleTimeout.
+//
+// It doesn't do any network I/O and is cheap enough for the liveness probes.
+func (m *Monitor) Healthy() bool {
+	if !m.connected.Load() {
+		return false
+	}
+
+	if m.staleTimeout <= 0 {
+		return true
+	}
+
+	return time.Since(time.Unix(0, m.lastRead.Load())) <= m.staleTimeout
+}
+
+// WithDropStaleBefore drops the events with the timestamp older than the given duration.
+// The default is zero, which means no events are dropped.
+//
+// Also, the events with the sequence not greater than the last accepted one
+// and the older timestamp are dropped as replayed after reconnect.
+//
+// Don't use it if the delayed events are legitimate for your application.
+func (m *Monitor) WithDropStaleBefore(d time.Duration) *Monitor {
+	m.staleBefore = d
+
+	return m
+}
+
+// WithDedupBySequence drops the events with the same sequence number as one
+// of the last window received events. A non-positive window disables it.
+//
+// The sequences are monotonic per FreeSWITCH run, so a backward jump beyond
+// the window is treated as the server restart and the window is reset.
+func (m *Monitor) WithDedupBySequence(window int) *Monitor {
+	m.dedup = nil
+	if window > 0 {
+		m.dedup = newSequenceDedup(window)
+	}
+
+	return m
+}
+
+// isStale returns true if the event is stale and should be dropped.
+//
+// Called only from the read loop.
+func (m *Monitor) isStale(e Event) bool {
+	if m.staleBefore <= 0 {
+		return false
+	}
+
+	ts := e.Timestamp()
+	if !ts.IsZero() && ts.Before(time.Now().Add(-m.staleBefore)) {
+		return true // too old
+	}
+
+	seq := e.Sequence()
+	if seq <= m.maxSequence && ts.Before(m.maxTimestamp) {
+		return true // replayed
+	}
+
+	// the delayed event with the newer sequence must not lower the bounds
+	m.maxSequence = max(m.maxSequence, seq)
+	if ts.After(m.maxTimestamp) {
+		m.maxTimestamp = ts
+	}
+
+	return false
+}
+
+// WithSequenceGapDetection enables the detection of the missed events
+// by the gaps in the Event-Sequence, for example, during reconnect.
+//
+// The forward gap is logged as a warning with the number of the missed events
+// and counted in Stats.SequenceGaps. The sequence going back is logged as
+// the server restart. The sequence is global for the server, so the detection
+// makes sense only if all events are subscribed, otherwise the gaps are expected.
+func (m *Monitor) WithSequenceGapDetection() *Monitor {
+	m.gaps = true
+
+	return m
+}
+
+// checkSequence logs and counts the gap between the last and the given sequence.
+//
+// Called only from the read loop.
+func (m *Monitor) checkSequence(seq int64) {
+	if seq <= 0 {
+		return // no sequence
+	}
+
+	last := m.lastSeq
+	m.lastSeq = seq
+
+	switch {
+	case last == 0 || seq == last+1: // the first or the next event
+	case seq > last:
+		m.stats.sequenceGaps.Add(1)
+		m.log.Warn("esl: event sequence gap",
+			slog.Int64("last", last),
+			slog.Int64("sequence", seq),
+			slog.Int64("missed", seq-last-1))
+	case seq < last:
+		m.log.Info("esl: event sequence reset",
+			slog.Int64("last", last),
+			slog.Int64("sequence", seq))
+	default: // the same sequence is the duplicate
+	}
+}
+
+// WithCloseChannelsOnExit closes the subscriber channels when Run returns,
+// so the consumers can range over them.
+//
+// The channels must not be shared with other monitors or closed by the caller.
+// The channels of SubscribeRaw are not closed.
+func (m *Monitor) WithCloseChannelsOnExit() *Monitor {
+	m.closeOnExit = true
+
+	return m
+}
+
+// closeSubscribers closes the channels of all subscribers once.
+func (m *Monitor) closeSubscribers() {
+	closed := make(map[chan<- Event]struct{})
+
+	for _, subscriber := range m.getSubscribers() {
+		if _, ok := closed[subscriber.Send]; ok {
+			continue // the same channel for several subscribers
+		}
+
+		closed[subscriber.Send] = struct{}{}
+		subscriber.Close()
+	}
+}
+
+// WithDrainTimeout sets the grace period for the blocked delivery to the
+// subscribers, including the raw ones, after the Run context is done.
+//
+// By default, the delivery to a subscriber blocks until the event is read,
+// so Run doesn't return while the consumer is stuck. With the drain timeout,
+// the consumers have the given time to read the in-flight event, after that
+// it's dropped and Run returns.
+func (m *Monitor) WithDrainTimeout(d time.Duration) *Monitor {
+	m.drainTimeout = d
+
+	return m
+}
+
+// drainDeadline returns the channel closed after the drain timeout
+// since the context is done, and the function to release the resources.
+//
+// Returns the nil channel if the drain timeout is not set.
+func (m *Monitor) drainDeadline(ctx context.Context) (<-chan struct{}, func() bool) {
+	if m.drainTimeout <= 0 {
+		return nil, func() bool { return false }
+	}
+
+	drained := make(chan struct{})
+	timeout := m.drainTimeout
+
+	return drained, context.AfterFunc(ctx, func() {
+		time.AfterFunc(timeout, func() { close(drained) })
+	})
+}
+
+// OnEvent adds the event handler called for every dispatched event
+// after it's sent to the subscribers.
+//
+// If the handler returns a non-nil error, Run stops without reconnect and
+// returns that error. The handler is called on the read goroutine, so it should be cheap.
+func (m *Monitor) OnEvent(fn func(Event) error) *Monitor {
+	m.handlers = append(m.handlers, fn)
+
+	return m
+}
+
+// stopError is the error returned by the event handler to stop the monitor.
+type stopError struct {
+	err error
+}
+
+func (e *stopError) Error() string { return "stopped by handler: " + e.err.Error() }
+func (e *stopError) Unwrap() error { return e.err }
+
+// WithTransform sets the function to enrich or redact the events before they are
+// sent to the subscribers. A nil transform disables it.
+//
+// The transform is called once per event on the read goroutine, so it should be cheap.
+// It's recommended to return a modified clone rather than mutate the event in place.
+// If the transform returns nil, the event is dropped.
+func (m *Monitor) WithTransform(fn func(Event) Event) *Monitor {
+	m.transform = fn
+
+	return m
+}
+
+// WithSourceTag adds the source tag to every event received by this monitor.
+//
+// It allows merging the events from several monitors (e.g. one per FreeSWITCH node)
+// into a single channel, subscribed to each of them, and telling them apart
+// with Event.Source.
+func (m *Monitor) WithSourceTag(name string) *Monitor {
+	m.source = name
+
+	return m
+}
+
+// WithEventTag sets the key to the value in every event received by this monitor.
+// It can be called several times to add several tags.
+//
+// It's the declarative alternative to WithTransform for stamping the events with
+// static metadata, like a tenant identifier. Use the underscore prefix for the key,
+// like "_tenant", to avoid the collision with the ESL headers, which are overwritten.
+func (m *Monitor) WithEventTag(key, value string) *Monitor {
+	if m.tags == nil {
+		m.tags = make(map[string]string)
+	}
+
+	m.tags[key] = value
+
+	return m
+}
+
+// WithHeaderWhitelist keeps only the listed headers in the events.
+//
+// The other headers are skipped by the parser without decoding, which
+// significantly reduces the parsing cost when only a few headers are used.
+// The Event-Name and Event-Subclass headers are always kept, as they are
+// required to dispatch the events. The body is kept too.
+// If no keys are provided, all headers are kept.
+func (m *Monitor) WithHeaderWhitelist(keys ...string) *Monitor {
+	if len(keys) == 0 {
+		m.parser.headers = nil
+
+		return m
+	}
+
+	m.parser.headers = make(map[string]struct{}, len(keys)+2) //nolint:mnd
+	m.parser.headers[eventNameKey] = struct{}{}
+	m.parser.headers[eventSubclassKey] = struct{}{}
+
+	for _, key := range keys {
+		m.parser.headers[key] = struct{}{}
+		// to match the keys parsed with WithCaseInsensitiveHeaders
+		m.parser.headers[canonicalHeaderKey(key)] = struct{}{}
+	}
+
+	return m
+}
+
+// WithDiscardBodies discards the bodies of the events with the given names
+// during parsing, or of all events if no names are provided.
+//
+// It reduces the memory of the buffered events, when the large bodies, like
+// CDR or XML, are not used. The original frame kept with WithRetainRawFrame
+// still contains the body.
+func (m *Monitor) WithDiscardBodies(events ...string) *Monitor {
+	m.parser.noBody = true
+	m.parser.noBodies = eventNamesSet(events)
+
+	return m
+}
+
+// WithVariablePrefix sets the non-standard prefix of the channel variable
+// headers used by some customized FreeSWITCH builds. An empty prefix
+// restores the default "variable_".
+//
+// The prefix is replaced with "variable_" during parsing, so Event.Variable,
+// WithVariableWhitelist and WithHeaderWhitelist work with the standard names.
+func (m *Monitor) WithVariablePrefix(prefix string) *Monitor {
+	m.parser.varPrefix = prefix
+	if prefix == variableKeyPrefix {
+		m.parser.varPrefix = "" // nothing to replace
+	}
+
+	return m
+}
+
+// WithLazyDecode keeps the event header values URL-encoded and decodes them
+// on demand in Event.Get and the other accessors.
+//
+// It saves the decoding of the values never read, which is significant for
+// the events with a hundred of channel variables when only a few are used.
+// The value is decoded on every call without caching, so the event is never
+// modified and is safe to share between the subscribers, but the value read
+// several times should be kept in a variable. The direct map access returns
+// the encoded values, while AsMap, LogHeaders and the JSON encoding, used by
+// the event log and ServeSSE, decode them. The transform set by WithTransform
+// gets the decoded event, so the decoding isn't saved with a transform.
+func (m *Monitor) WithLazyDecode() *Monitor {
+	m.parser.lazy = true
+
+	return m
+}
+
+// WithRetainRawFrame keeps the original frame body of every event,
+// available with Event.Raw, for example, to archive the exact data sent
+// by the server.
+//
+// It's disabled by default, as it keeps the whole frame in memory with every
+// event, even if only a few headers are used.
+func (m *Monitor) WithRetainRawFrame() *Monitor {
+	m.parser.raw = true
+
+	return m
+}
+
+// WithCaseInsensitiveHeaders converts the event header keys to the canonical
+// form during parsing, so the headers emitted with inconsistent case are
+// stored under the same key.
+//
+// The canonical form is the same as for the HTTP headers: the first letter and
+// any letter following a hyphen are upper case, the rest are lower case, for
+// example, "event-name" becomes "Event-Name" and "Unique-ID" becomes "Unique-Id".
+// The "variable_" prefix is converted to the lower case, and the variable
+// names are kept as is. Event.Get falls back to the canonical form of the key,
+// so both "Unique-ID" and "unique-id" work, while the direct map access requires
+// the canonical key.
+//
+// By default, the header keys are kept as sent by the server.
+func (m *Monitor) WithCaseInsensitiveHeaders() *Monitor {
+	m.parser.canonical = true
+
+	return m
+}
+
+// WithVariableWhitelist keeps only the listed channel variables in the events.
+//
+// All other "variable_" headers are removed from each event before it is sent
+// to subscribers. The names are given without the "variable_" prefix.
+// If no names are provided, all channel variables are kept.
+func (m *Monitor) WithVariableWhitelist(names ...string) *Monitor {
+	if len(names) == 0 {
+		m.variables = nil
+
+		return m
+	}
+
+	m.variables = make(map[string]struct{}, len(names))
+	for _, name := range names {
+		m.variables[variableKeyPrefix+name] = struct{}{}
+	}
+
+	return m
+}
+
+// WithEventRingBuffer keeps the n most recent events for post-mortem debugging.
+//
+// All received events are stored as received, regardless of the subscribers,
+// before the transform and tags. Use RecentEvents to get them. A non-positive n disables the buffer.
+func (m *Monitor) WithEventRingBuffer(n int) *Monitor {
+	m.recent = nil
+	if n > 0 {
+		m.recent = newEventRing(n)
+	}
+
+	return m
+}
+
+// RecentEvents returns a snapshot of the most recent events, from the oldest
+// to the newest.
+//
+// Returns nil if the ring buffer is not enabled with WithEventRingBuffer.
+func (m *Monitor) RecentEvents() []Event {
+	if m.recent == nil {
+		return nil
+	}
+
+	return m.recent.Events()
+}
+
+// filterVariables removes the channel variables not listed in the whitelist.
+func (m *Monitor) filterVariables(e Event) {
+	if m.variables == nil {
+		return // keep all variables
+	}
+
+	for key := range e {
+		if !strings.HasPrefix(key, variableKeyPrefix) {
+			continue
+		}
+
+		if _, ok := m.variables[key]; !ok {
+			delete(e, key)
+		}
+	}
+}
+
+// isInternal returns true if the event is received only for the heartbeat
+// watchdog or the watchers, and no subscriber asked for it.
+func (m *Monitor) isInternal(name string) bool {
+	if (name != "HEARTBEAT" || m.heartbeat <= 0) && !m.watchers.Has(name) {
+		return false
+	}
+
+	return m.rawSubscribe == "" && len(m.lookupSubscribers(name)) == 0
+}
+
+// dispatch filters the received event and sends it to the subscribers.
+//
+// The blocked delivery is abandoned when the drained channel is closed.
+// Returns the error of the OnEvent handler to stop the monitor.
+// Called only from the read loop.
+func (m *Monitor) dispatch(event Event, drained <-chan struct{}) error {
+	// the events subscribed only for the monitor needs are not user-facing
+	internal := m.isInternal(event.Name())
+	if !internal {
+		m.stats.countEvent(event.Name())
+	}
+
+	if m.gaps {
+		m.checkSequence(event.Sequence())
+	}
+	m.filterVariables(event)
+
+	if m.recent != nil && !internal {
+		// the copy, as the event is modified by the source and event tags,
+		// while the ring is read concurrently
+		m.recent.Add(maps.Clone(event))
+	}
+
+	if m.isStale(event) {
+		return nil
+	}
+
+	if m.dedup != nil && m.dedup.IsDuplicate(event.Sequence()) {
+		return nil
+	}
+
+	if seq := event.Sequence(); seq > m.seenSeq.Load() && !internal {
+		m.seenSeq.Store(seq) // the only writer is the read loop
+	}
+
+	if m.transform != nil {
+		event.decodeAll() // the values set by the transform are never decoded
+
+		if event = m.transform(event); event == nil {
+			return nil // dropped by the transform
+		}
+	}
+
+	if m.source != "" {
+		event[sourceKey] = m.source
+	}
+
+	for key, value := range m.tags {
+		event.setLiteral(key, value)
+	}
+
+	if m.eventLog != nil && !internal {
+		m.eventLog.Write(event)
+	}
+
+	m.watchers.Handle(event)
+
+	if internal {
+		return nil // no subscribers and handlers
+	}
+
+	if m.paused.Load() {
+		m.stats.paused.Add(1)
+	} else {
+		for _, subscriber := range m.lookupSubscribers(event.Name()) {
+			subscriber.Handle(event, drained)
+		}
+	}
+
+	for _, handler := range m.handlers {
+		if err := handler(event); err != nil {
+			return &stopError{err: err}
+		}
+	}
+
+	return nil
+}
+
+// initSubscriptions subscribes the new connection to the ESL events if
+// subscribers are set, the server accumulates the events of several commands.
+// Returns true if any subscribe command was sent, and the frames received
+// before the last reply, to be handled after the connection is set.
+func (m *Monitor) initSubscriptions(ctx context.Context, eslConn *esl.Conn) (bool, []esl.Response, error) {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+
+	plan := m.SubscriptionPlan()
+	cmds := m.subscribe(plan)
+
+	// the first command starts the event stream, so the events can come
+	// before the replies to the next ones
+	var early []esl.Response
+
+	replies, err := eslConn.ExecCtx(ctx, cmds, func(resp esl.Response) {
+		early = append(early, resp)
+	})
+	if err != nil {
+		return false, nil, fmt.Errorf("subscribe: %w", err)
+	}
+
+	for _, resp := range replies {
+		if err = resp.AsErr(); err != nil {
+			return false, nil, fmt.Errorf("subscribe response: %w", err)
+		}
+
+		// some modules reply with an error without the "-ERR" prefix
+		if !strings.HasPrefix(resp.Text, "+OK") {
+			return false, nil, fmt.Errorf("subscribe response: unexpected reply: %q", resp.Text)
+		}
+	}
+
+	m.applied, m.resubscribe = plan, false
+
+	return len(cmds) > 0, early, nil
+}
+
+// subscribe returns the commands to subscribe to the planned events.
+// Returns nil if there is nothing to subscribe.
+func (m *Monitor) subscribe(plan SubscriptionPlan) []string {
+	if m.rawSubscribe != "" {
+		return []string{m.rawSubscribe}
+	}
+
+	return plan.commands(maxSubscribeLength)
+}
+
+// subscriptionChanges returns the commands to change the server subscription
+// to the current plan and the plan itself. The caller must hold applyMu.
+func (m *Monitor) subscriptionChanges() ([]string, SubscriptionPlan) {
+	if m.resubscribe {
+		plan := m.SubscriptionPlan()
+
+		return append([]string{"noevents"}, m.subscribe(plan)...), plan
+	}
+
+	if m.rawSubscribe != "" {
+		return nil, m.applied // the raw command doesn't depend on the subscribers
+	}
+
+	plan := m.SubscriptionPlan()
+
+	return m.applied.changes(plan, maxSubscribeLength), plan
+}
+
+// subscriptionsChanged returns true if the server subscription differs
+// from the current plan.
+func (m *Monitor) subscriptionsChanged() bool {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+
+	cmds, _ := m.subscriptionChanges()
+
+	return len(cmds) > 0
+}
+
+// WithRawSubscribeCommand sets the command sent to subscribe to the events
+// instead of the generated one, like "event plain ALL". An empty command
+// restores the generated one.
+//
+// It's an escape hatch for the servers expecting the other command: the event
+// names of the subscribers, the heartbeat and the watchers are not used for
+// the server-side filter, but the events are still delivered to the subscribers
+// by their names. The command is sent even without subscribers.
+// Only the plain events are parsed, the other formats, like "event json",
+// are handled as the unknown content types and can be read with SubscribeRaw.
+func (m *Monitor) WithRawSubscribeCommand(cmd string) *Monitor {
+	m.rawSubscribe = cmd
+
+	return m
+}
+
+// SubscriptionPlan describes the events the monitor subscribes to on the server.
+type SubscriptionPlan struct {
+	Known  []string // known event names, sorted
+	Custom []string // CUSTOM event subclasses, sorted
+	All    bool     // all events are subscribed
+}
+
+// SubscriptionPlan returns the events the monitor subscribes to with the
+// current subscribers, split to the known events and the CUSTOM subclasses.
+//
+// It helps to find out why a custom event isn't received, and can be called
+// before Run. The names unknown to the library are subscribed as the CUSTOM
+// subclasses, use RegisterEventName to add the new event names.
+func (m *Monitor) SubscriptionPlan() SubscriptionPlan {
+	var plan SubscriptionPlan
+
+	subscribers, watched := m.getSubscribers(), m.watchers.Names()
+	events := make(map[string]struct{}, len(watched)+1)
+
+	for _, subscriber := range subscribers {
+		if len(subscriber.Names) == 0 {
+			plan.All = true // all events should be handled
+
+			return plan
+		}
+
+		maps.Copy(events, subscriber.Names)
+	}
+
+	// the heartbeat watchdog needs the HEARTBEAT events:
+	// they are not delivered to the subscribers that didn't ask for them
+	if m.heartbeat > 0 {
+		events["HEARTBEAT"] = struct{}{}
+	}
+
+	// the same for the events waited by the watchers
+	maps.Copy(events, watched)
+
+	return newSubscriptionPlan(events)
+}
+
+// newSubscriptionPlan returns the plan to subscribe to the given event names.
+func newSubscriptionPlan(events map[string]struct{}) SubscriptionPlan {
+	var plan SubscriptionPlan
+
+	for name := range events {
+		if isEventName(name) {
+			plan.Known = append(plan.Known, name)
+		} else {
+			plan.Custom = append(plan.Custom, name)
+		}
+	}
+
+	slices.Sort(plan.Known)
+	slices.Sort(plan.Custom)
+
+	return plan
+}
+
+// maxSubscribeLength is the maximum length of the subscribe command,
+// the longer ones are split, as some servers truncate the long command lines.
+const maxSubscribeLength = 1024
+
+const (
+	cmdSubscribe   = "event plain"
+	cmdUnsubscribe = "nixevent"
+)
+
+// commands returns the ESL commands to subscribe to the planned events.
+// The names are split to several commands not longer than maxLen,
+// unless a single name is longer. Returns nil if there is nothing to subscribe.
+func (p SubscriptionPlan) commands(maxLen int) []string {
+	if p.All {
+		return []string{cmdSubscribe + " ALL"}
+	}
+
+	cmds := splitCommand(nil, cmdSubscribe, p.Known, maxLen)
+	cmds = splitCommand(cmds, cmdSubscribe+" CUSTOM", p.Custom, maxLen)
+
+	return cmds
+}
+
+// changes returns the ESL commands to change the server subscription from
+// the plan p to the next one: the added events are subscribed with "event",
+// the removed ones are unsubscribed with "nixevent", so the other events
+// are delivered without a gap. Returns nil if the plans are the same.
+//
+// A single event can't be removed from the ALL subscription, so it's reset
+// with "noevents" before subscribing to the next events.
+func (p SubscriptionPlan) changes(next SubscriptionPlan, maxLen int) []string {
+	switch {
+	case next.All:
+		if p.All {
+			return nil
+		}
+
+		return next.commands(maxLen)
+	case p.All:
+		return append([]string{"noevents"}, next.commands(maxLen)...)
+	}
+
+	cmds := splitCommand(nil, cmdSubscribe, subtract(next.Known, p.Known), maxLen)
+	cmds = splitCommand(cmds, cmdSubscribe+" CUSTOM", subtract(next.Custom, p.Custom), maxLen)
+	cmds = splitCommand(cmds, cmdUnsubscribe, subtract(p.Known, next.Known), maxLen)
+	cmds = splitCommand(cmds, cmdUnsubscribe+" CUSTOM", subtract(p.Custom, next.Custom), maxLen)
+
+	return cmds
+}
+
+// splitCommand appends to cmds the commands with the prefix and the names,
+// not longer than maxLen unless a single name is longer.
+func splitCommand(cmds []string, prefix string, names []string, maxLen int) []string {
+	var cmd strings.Builder
+
+	for _, name := range names {
+		if cmd.Len() > 0 && cmd.Len()+1+len(name) > maxLen {
+			cmds = append(cmds, cmd.String())
+			cmd.Reset()
+		}
+
+		if cmd.Len() == 0 {
+			cmd.WriteString(prefix)
+		}
+
+		cmd.WriteByte(' ')
+		cmd.WriteString(name)
+	}
+
+	if cmd.Len() > 0 {
+		cmds = append(cmds, cmd.String())
+	}
+
+	return cmds
+}
+
+// subtract returns the names missing in the sorted slice from.
+func subtract(names, from []string) []string {
+	var diff []string
+
+	for _, name := range names {
+		if _, found := slices.BinarySearch(from, name); !found {
+			diff = append(diff, name)
+		}
+	}
+
+	return diff
+}
+
+// unixSocketPath returns the Unix domain socket path if the address is
+// an absolute path or has the "unix://" prefix.
+func unixSocketPath(addr string) (string, bool) {
+	if path, ok := strings.CutPrefix(addr, "unix://"); ok {
+		return path, true
+	}
+
+	return addr, strings.HasPrefix(addr, "/")
+}
+
+// addAddrPort adds a default port to the given address if it doesn't contain a port.
+// If the address contains a port, it is returned as is.
+// Panics if the address is invalid.
+func addAddrPort(addr string) string {
+	addr, err := normalizeAddr(addr)
+	if err != nil {
+		//nolint:forbidigo // I don't want to return an error only for this
+		panic(err)
+	}
+
+	return addr
+}
+
+// normalizeAddr adds a default port to the given address if it doesn't contain a port.
+// Returns an error if the address is invalid.
+func normalizeAddr(addr string) (string, error) {
+	// if the address doesn't contain a port, use the default port
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		var addrErr *net.AddrError
+		if !errors.As(err, &addrErr) || addrErr.Err != "missing port in address" {
+			return "", fmt.Errorf("bad address: %w", err)
+		}
+
+		addr = net.JoinHostPort(addr, defaultPort)
+	}
+
+	return addr, nil
 }
@@ -1,9 +1,16 @@
 package esl
 
 import (
+	"bufio"
 	"context"
 	"errors"
+	"fmt"
+	"net"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -35,3 +42,456 @@ func TestRealConnection(t *testing.T) {
 
 	t.Error(monitor.Run(ctx))
 }
+
+func TestCommandOnly(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	commands := make(chan string, 2)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		readCommand := func() string {
+			var lines []string
+
+			for {
+				line, err := r.ReadString('\n')
+				if line = strings.TrimSpace(line); err != nil || line == "" {
+					return strings.Join(lines, "\n")
+				}
+
+				lines = append(lines, line)
+			}
+		}
+
+		fmt.Fprint(conn, "Content-Type: auth/request\n\n")
+		readCommand()
+		fmt.Fprint(conn, "Content-Type: command/reply\nReply-Text: +OK accepted\n\n")
+
+		// the unsolicited event is read and discarded
+		const event = "Event-Name: HEARTBEAT\n\n"
+		fmt.Fprintf(conn, "Content-Type: text/event-plain\nContent-Length: %d\n\n%s", len(event), event)
+
+		for cmd := readCommand(); cmd != ""; cmd = readCommand() {
+			commands <- cmd
+			fmt.Fprint(conn, "Content-Type: api/response\nContent-Length: 3\n\n+OK")
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var recorder commandRecorder
+
+	monitor := New(ln.Addr().String(), "ClueCon").withCommandRecorder(&recorder)
+	errc := make(chan error, 1)
+
+	go func() { errc <- monitor.Run(ctx) }()
+
+	select {
+	case <-monitor.Ready():
+	case err := <-errc:
+		t.Fatal(err)
+	}
+
+	if err := monitor.Exec(ctx, "api status"); err != nil {
+		t.Fatal(err)
+	}
+
+	// no subscribe command is sent without subscribers
+	if cmd := <-commands; cmd != "api status" {
+		t.Errorf("unexpected command: %q", cmd)
+	}
+
+	if !monitor.Healthy() {
+		t.Error("the command-only connection is not healthy")
+	}
+
+	cancel()
+
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected Run error: %v", err)
+	}
+
+	if got, want := recorder.String(), "auth ClueCon\n\napi status\n\n"; got != want {
+		t.Errorf("sent commands = %q, want %q", got, want)
+	}
+}
+
+func TestFirstEventProbe(t *testing.T) {
+	for _, silent := range []bool{false, true} {
+		t.Run(fmt.Sprintf("silent=%v", silent), func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+
+				r := bufio.NewReader(conn)
+				fmt.Fprint(conn, "Content-Type: auth/request\n\n")
+
+				// auth and subscribe are accepted, then no events are sent
+				for replies := 0; ; replies++ {
+					if _, err := r.ReadString('\n'); err != nil {
+						return
+					}
+
+					if _, err := r.ReadString('\n'); err != nil {
+						return // the empty line after the command
+					}
+
+					switch {
+					case replies < 2:
+						fmt.Fprint(conn, "Content-Type: command/reply\nReply-Text: +OK\n\n")
+					case !silent:
+						fmt.Fprint(conn, "Content-Type: api/response\nContent-Length: 3\n\n+OK")
+					}
+				}
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+			defer cancel()
+
+			err = New(ln.Addr().String(), "ClueCon").
+				Subscribe(make(chan Event), "CHANNEL_CREATE").
+				WithCommandsTimeout(50 * time.Millisecond).
+				Run(ctx)
+
+			if silent && !errors.Is(err, ErrTimeout) {
+				t.Errorf("the silent server: Run error = %v, want timeout", err)
+			}
+
+			if !silent && !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("the idle server: Run error = %v, want context done", err)
+			}
+		})
+	}
+}
+
+// commandRecorder records the commands sent by the monitor.
+type commandRecorder struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (r *commandRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.buf.Write(p) //nolint:wrapcheck
+}
+
+func (r *commandRecorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.buf.String()
+}
+
+func TestCommandArgsInjection(t *testing.T) {
+	monitor := New("localhost", "")
+	ctx := context.Background()
+
+	for name, err := range map[string]error{
+		"hangup":   monitor.Hangup(ctx, "uuid\n\napi system reboot", ""),
+		"transfer": monitor.Transfer(ctx, "uuid", "1000\r\n"),
+		"set var":  monitor.SetVar(ctx, "uuid", "name", "value\n"),
+		"myevents": monitor.MyEventsWith(ctx, "uuid", "HEARTBEAT\n"),
+	} {
+		if !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("%s: expected ErrInvalidArgument, got %v", name, err)
+		}
+	}
+}
+
+func TestSubscribeSplit(t *testing.T) {
+	const count = 150
+
+	names := []string{"CHANNEL_CREATE", "CHANNEL_ANSWER"}
+	for i := range count {
+		names = append(names, fmt.Sprintf("module::long_custom_event_subclass_%03d", i))
+	}
+
+	monitor := New("localhost", "").Subscribe(make(chan Event), names...)
+	cmds := monitor.subscribe(monitor.SubscriptionPlan())
+	if len(cmds) < 2 {
+		t.Fatalf("expected several commands, got %d", len(cmds))
+	}
+
+	got := make(map[string]bool)
+
+	for _, cmd := range cmds {
+		if len(cmd) > maxSubscribeLength {
+			t.Errorf("command is too long: %d", len(cmd))
+		}
+
+		rest, ok := strings.CutPrefix(cmd, "event plain ")
+		if !ok {
+			t.Fatalf("unexpected command: %q", cmd)
+		}
+
+		custom := strings.HasPrefix(rest, "CUSTOM ")
+		for _, name := range strings.Fields(rest) {
+			if name == "CUSTOM" {
+				continue
+			}
+
+			if custom == isEventName(name) {
+				t.Errorf("%q is subscribed with the wrong command", name)
+			}
+
+			got[name] = true
+		}
+	}
+
+	// the server-side filter is the union of all commands
+	if len(got) != len(names) {
+		t.Errorf("subscribed %d names, want %d", len(got), len(names))
+	}
+
+	for _, name := range names {
+		if !got[name] {
+			t.Errorf("%q is not subscribed", name)
+		}
+	}
+}
+
+func TestSubscribeSplitEvents(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		fmt.Fprint(conn, "Content-Type: auth/request\n\n")
+
+		for replies := 0; ; replies++ {
+			for { // read the command until the empty line
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+
+				if line == "\n" {
+					break
+				}
+			}
+
+			fmt.Fprint(conn, "Content-Type: command/reply\nReply-Text: +OK\n\n")
+
+			// the first subscribe command starts the event stream
+			if replies == 1 {
+				const event = "Event-Name: CHANNEL_CREATE\n\n"
+				fmt.Fprintf(conn, "Content-Type: text/event-plain\nContent-Length: %d\n\n%s", len(event), event)
+			}
+		}
+	}()
+
+	names := []string{"CHANNEL_CREATE"}
+	for i := range 150 {
+		names = append(names, fmt.Sprintf("module::long_custom_event_subclass_%03d", i))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	events := make(chan Event, 1)
+	monitor := New(ln.Addr().String(), "ClueCon").Subscribe(events, names...)
+	errc := make(chan error, 1)
+
+	go func() { errc <- monitor.Run(ctx) }()
+
+	select {
+	case event := <-events:
+		if event.Name() != "CHANNEL_CREATE" {
+			t.Errorf("unexpected event: %q", event.Name())
+		}
+	case err := <-errc:
+		t.Fatal(err)
+	}
+
+	cancel()
+	<-errc
+}
+
+func TestSubscriptionChanges(t *testing.T) {
+	known := SubscriptionPlan{Known: []string{"CHANNEL_ANSWER", "CHANNEL_CREATE"}, Custom: []string{"sofia::register"}}
+	all := SubscriptionPlan{All: true}
+
+	tests := []struct {
+		name       string
+		prev, next SubscriptionPlan
+		want       []string
+	}{
+		{"same", known, known, nil},
+		{"from nothing", SubscriptionPlan{}, known, known.commands(maxSubscribeLength)},
+		{"to nothing", known, SubscriptionPlan{}, []string{
+			"nixevent CHANNEL_ANSWER CHANNEL_CREATE",
+			"nixevent CUSTOM sofia::register",
+		}},
+		{"add and remove", known, SubscriptionPlan{
+			Known:  []string{"CHANNEL_CREATE", "CHANNEL_HANGUP"},
+			Custom: []string{"sofia::register", "sofia::unregister"},
+		}, []string{
+			"event plain CHANNEL_HANGUP",
+			"event plain CUSTOM sofia::unregister",
+			"nixevent CHANNEL_ANSWER",
+		}},
+		{"to all", known, all, []string{"event plain ALL"}},
+		{"all to all", all, all, nil},
+		{"from all", all, known, append([]string{"noevents"}, known.commands(maxSubscribeLength)...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.prev.changes(tt.next, maxSubscribeLength); !slices.Equal(got, tt.want) {
+				t.Errorf("changes() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionChangesResubscribe(t *testing.T) {
+	monitor := New("localhost", "").Subscribe(make(chan Event), "CHANNEL_CREATE")
+	monitor.applied = monitor.SubscriptionPlan()
+
+	if cmds, _ := monitor.subscriptionChanges(); len(cmds) != 0 {
+		t.Fatalf("subscriptionChanges() = %q, want nothing", cmds)
+	}
+
+	monitor.resubscribe = true // after myevents
+
+	want := append([]string{"noevents"}, monitor.SubscriptionPlan().commands(maxSubscribeLength)...)
+	if cmds, _ := monitor.subscriptionChanges(); !slices.Equal(cmds, want) {
+		t.Errorf("subscriptionChanges() = %q, want %q", cmds, want)
+	}
+}
+
+func TestDropStaleReplayed(t *testing.T) {
+	events := make(chan Event, 10)
+	monitor := New("localhost", "").
+		Subscribe(events, "HEARTBEAT").
+		WithDropStaleBefore(time.Hour)
+
+	now := time.Now()
+	for _, e := range []struct {
+		seq int
+		age time.Duration
+	}{
+		{10, 0},
+		{11, 2 * time.Second}, // delayed, but newer
+		{6, time.Second},      // replayed after the delayed one
+		{5, 3 * time.Second},  // replayed
+		{12, 0},
+	} {
+		event := Event{
+			eventNameKey:      "HEARTBEAT",
+			eventSequenceKey:  strconv.Itoa(e.seq),
+			eventTimestampKey: strconv.FormatInt(now.Add(-e.age).UnixMicro(), 10),
+		}
+		if err := monitor.dispatch(event, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	close(events)
+
+	var got []int64
+	for event := range events {
+		got = append(got, event.Sequence())
+	}
+
+	if want := []int64{10, 11, 12}; !slices.Equal(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+}
+
+func TestInternalEventsHidden(t *testing.T) {
+	var handled []string
+
+	monitor := New("localhost", "").
+		Subscribe(make(chan Event, 1), "CHANNEL_CREATE").
+		WithHeartbeatTimeout(time.Minute).
+		WithEventRingBuffer(10).
+		OnEvent(func(e Event) error {
+			handled = append(handled, e.Name())
+
+			return nil
+		})
+
+	for i, name := range []string{"CHANNEL_CREATE", "HEARTBEAT"} {
+		event := Event{eventNameKey: name, eventSequenceKey: strconv.Itoa(i + 1)}
+		if err := monitor.dispatch(event, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the heartbeat is subscribed only for the watchdog
+	if want := []string{"CHANNEL_CREATE"}; !slices.Equal(handled, want) {
+		t.Errorf("handled %v, want %v", handled, want)
+	}
+
+	if got := len(monitor.RecentEvents()); got != 1 {
+		t.Errorf("recent events: %d, want 1", got)
+	}
+
+	if counts := monitor.EventCounts(); counts["HEARTBEAT"] != 0 || counts["CHANNEL_CREATE"] != 1 {
+		t.Errorf("unexpected counts: %v", counts)
+	}
+
+	if got := monitor.SeenSequence(); got != 1 {
+		t.Errorf("SeenSequence() = %d, want 1", got)
+	}
+}
+
+func TestRecentEventsConcurrent(t *testing.T) {
+	monitor := New("localhost", "").
+		WithEventRingBuffer(1).
+		WithSourceTag("node1").
+		WithEventTag("_tenant", "acme")
+
+	var wg sync.WaitGroup
+
+	for i := range 1000 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for _, event := range monitor.RecentEvents() {
+				_ = event.Source()
+			}
+		}()
+
+		event := Event{eventNameKey: "HEARTBEAT", eventSequenceKey: strconv.Itoa(i + 1)}
+		if err := monitor.dispatch(event, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,78 @@
+package esl
+
+import (
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+// WithReconnectPolicy enables the reconnection with the given policy.
+// The reconnection is disabled by default.
+//
+// The policy is called with the error of the failed connection and returns
+// true to reconnect. A nil policy sets the DefaultReconnectPolicy.
+// The context cancellation always stops Run without calling the policy.
+func (m *Monitor) WithReconnectPolicy(policy func(error) bool) *Monitor {
+	if policy == nil {
+		policy = DefaultReconnectPolicy
+	}
+
+	m.reconnect = policy
+
+	return m
+}
+
+// DefaultReconnectPolicy returns true for the network errors, timeouts,
+// broken connection writes and server disconnects, including the frames
+// truncated by the disconnect.
+// The authentication and other errors are not retried.
+func DefaultReconnectPolicy(err error) bool {
+	if errors.Is(err, ErrAccessDenied) || errors.Is(err, ErrInvalidPassword) {
+		return false
+	}
+
+	var netErr net.Error
+
+	return errors.As(err, &netErr) ||
+		errors.Is(err, ErrWrite) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, ErrTimeout)
+}
+
+// WithReconnectDelayFunc sets the function returning the delay before
+// the reconnection attempt. A nil function sets the DefaultReconnectDelay.
+//
+// The function is called with the zero-based attempt number, reset after
+// a successful connection, and the error of the failed connection.
+// It's used only if the reconnection is enabled with WithReconnectPolicy,
+// so to retry after the authentication errors with a longer delay,
+// the policy should allow them too.
+func (m *Monitor) WithReconnectDelayFunc(delay func(attempt int, err error) time.Duration) *Monitor {
+	if delay == nil {
+		delay = DefaultReconnectDelay
+	}
+
+	m.delayFunc = delay
+
+	return m
+}
+
+// DefaultReconnectDelay returns the exponential backoff delay from 1 to 30 seconds
+// with up to 20% of jitter for the given attempt. The error is ignored.
+func DefaultReconnectDelay(attempt int, _ error) time.Duration {
+	const (
+		minDelay = time.Second
+		maxDelay = time.Second * 30
+	)
+
+	delay := maxDelay
+	if attempt < 5 { //nolint:mnd // 2^5 seconds is more than the maximum
+		delay = min(minDelay<<attempt, maxDelay)
+	}
+
+	// add up to 20% of jitter to avoid reconnection storms
+	return delay + rand.N(delay/5) //nolint:gosec
+}
@@ -0,0 +1,51 @@
+package esl
+
+import "sync"
+
+// eventRing keeps the most recent events.
+type eventRing struct {
+	mu     sync.Mutex
+	events []Event // ring storage
+	next   int     // index for the next event
+	full   bool    // the ring was wrapped
+}
+
+// newEventRing returns a new ring for the given number of events.
+func newEventRing(size int) *eventRing {
+	return &eventRing{
+		mu:     sync.Mutex{},
+		events: make([]Event, size),
+		next:   0,
+		full:   false,
+	}
+}
+
+// Add adds the event to the ring, replacing the oldest one if it is full.
+func (r *eventRing) Add(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events[r.next] = e
+	r.next++
+
+	if r.next == len(r.events) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// Events returns a copy of the stored events, ordered from the oldest to the newest.
+func (r *eventRing) Events() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.full {
+		return append([]Event(nil), r.events[:r.next]...)
+	}
+
+	events := make([]Event, 0, len(r.events))
+	events = append(events, r.events[r.next:]...)
+	events = append(events, r.events[:r.next]...)
+
+	return events
+}
@@ -0,0 +1,44 @@
+package esl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// ServeSSE streams the events to the HTTP client as Server-Sent Events.
+//
+// Each event is written as a JSON-encoded "data:" frame and flushed.
+// The subscription lasts until the request context is done.
+// If no events are provided, all events are streamed.
+//
+// The events are dropped if the client can't keep up, so a slow or stalled
+// client never blocks the monitor. Use Event.Sequence to detect the gaps.
+func (m *Monitor) ServeSSE(w http.ResponseWriter, r *http.Request, events ...string) {
+	rc := http.NewResponseController(w)
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if err := rc.Flush(); err != nil {
+		return // streaming is not supported
+	}
+
+	for event := range m.subscribeContext(r.Context(), DeliveryDrop, events...) {
+		data, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return // the subscription is closed with the request context
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
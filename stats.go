@@ -0,0 +1,67 @@
+package esl
+
+import (
+	"maps"
+	"sync"
+	"sync/atomic"
+)
+
+// Stats contains the monitor statistics since it was created.
+type Stats struct {
+	Events          uint64 // received events
+	EventLogDropped uint64 // events dropped by the event log on the full buffer
+	Filtered        uint64 // frames dropped by the content type filter
+	SequenceGaps    uint64 // event sequence gaps, see WithSequenceGapDetection
+	Undelivered     uint64 // events not sent to the subscribers while the dispatch is disabled
+}
+
+// stats collects the monitor statistics.
+type stats struct {
+	events       atomic.Uint64
+	filtered     atomic.Uint64 // frames dropped by the content type filter
+	sequenceGaps atomic.Uint64 // event sequence gaps
+	paused       atomic.Uint64 // events not delivered while the dispatch is disabled
+
+	mu     sync.Mutex        // protects counts
+	counts map[string]uint64 // received events by name
+}
+
+// countEvent counts the received event with the given name.
+func (s *stats) countEvent(name string) {
+	s.events.Add(1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.counts == nil {
+		const countsCapacity = 100
+		s.counts = make(map[string]uint64, countsCapacity)
+	}
+
+	s.counts[name]++
+}
+
+// Stats returns the monitor statistics.
+func (m *Monitor) Stats() Stats {
+	stats := Stats{
+		Events:          m.stats.events.Load(),
+		EventLogDropped: 0,
+		Filtered:        m.stats.filtered.Load(),
+		SequenceGaps:    m.stats.sequenceGaps.Load(),
+		Undelivered:     m.stats.paused.Load(),
+	}
+
+	if m.eventLog != nil {
+		stats.EventLogDropped = m.eventLog.dropped.Load()
+	}
+
+	return stats
+}
+
+// EventCounts returns a snapshot of the received events count by the event name.
+func (m *Monitor) EventCounts() map[string]uint64 {
+	m.stats.mu.Lock()
+	defer m.stats.mu.Unlock()
+
+	return maps.Clone(m.stats.counts)
+}
@@ -1,51 +1,417 @@
 package esl
 
-import "strings"
+import (
+	"context"
+	"fmt"
+	"iter"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+)
 
 // subscriber represents an ESL event subscriber.
 type subscriber struct {
 	Names map[string]struct{} // event names to handle and custom flag
 	Send  chan<- Event        // send channel
+
+	done        chan struct{} // closed on removal to release the blocked send
+	releaseOnce sync.Once     // to close done once
+	mu          sync.RWMutex  // to close the send channel safely
+	closed      bool          // the send channel is closed
+	closeOnce   sync.Once     // to close once
+	drop        bool          // drop the events if the channel is full, set before adding
 }
 
 // newSubscriber creates a new subscriber with the given names and send channel.
 // If no event names are provided, all events are handled.
 //
-// If the send channel is nil, it panics.
-func newSubscriber(send chan<- Event, events ...string) subscriber {
+// If the send channel is nil, it panics with ErrNilChannel.
+func newSubscriber(send chan<- Event, events ...string) *subscriber {
 	if send == nil {
 		//nolint:forbidigo // I don't want to return only this error
-		panic("send channel cannot be nil")
+		panic(ErrNilChannel)
+	}
+
+	return &subscriber{
+		Names:       eventNamesSet(events),
+		Send:        send,
+		done:        make(chan struct{}),
+		releaseOnce: sync.Once{},
+		mu:          sync.RWMutex{},
+		closed:      false,
+		closeOnce:   sync.Once{},
+		drop:        false,
 	}
+}
 
+// eventNamesSet returns the set of the event names.
+// Returns nil if all events should be handled.
+func eventNamesSet(events []string) map[string]struct{} {
 	if len(events) == 0 { // all events should be handled
-		return subscriber{Names: nil, Send: send}
+		return nil
 	}
 
 	eventNames := make(map[string]struct{}, len(events))
 
 	for _, name := range events {
 		if name == "" || name == "*" || strings.EqualFold(name, "all") {
-			return subscriber{Names: nil, Send: send} // all events
+			return nil // all events
 		}
 
 		name, _ := strings.CutPrefix(name, "CUSTOM ")
 		eventNames[name] = struct{}{}
 	}
 
-	return subscriber{Names: eventNames, Send: send}
+	return eventNames
 }
 
 // Handle sends the event to the subscriber's send channel if the event
 // is handled by this subscriber.
 //
-// Returns true if the event was handled.
-func (s subscriber) Handle(e Event) bool {
-	if _, ok := s.Names[e.Name()]; ok || len(s.Names) == 0 {
-		s.Send <- e
+// The send blocks until the channel is ready, unless the subscriber drops
+// the events on the full channel. The blocked send is abandoned when
+// the drained channel is closed. Returns true if the event was handled.
+func (s *subscriber) Handle(e Event, drained <-chan struct{}) bool {
+	if _, ok := s.Names[e.Name()]; !ok && len(s.Names) != 0 {
+		return false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return false
+	}
 
+	select {
+	case <-s.done:
+		return false // removed
+	default:
+	}
+
+	if s.drop {
+		select {
+		case s.Send <- e:
+			return true
+		default: // the channel is full
+			return false
+		}
+	}
+
+	select {
+	case s.Send <- e:
 		return true
+	case <-s.done: // the subscriber is removed
+		return false
+	case <-drained: // never if the drain timeout is not set
+		return false
+	}
+}
+
+// Release stops the delivery and releases the blocked send,
+// the send channel is left open.
+func (s *subscriber) Release() {
+	s.releaseOnce.Do(func() { close(s.done) })
+}
+
+// Close releases the blocked send and closes the send channel.
+func (s *subscriber) Close() {
+	s.closeOnce.Do(func() {
+		s.Release()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.closed = true
+		close(s.Send)
+	})
+}
+
+// SubscribeContext adds a new subscriber for the given events and returns its channel.
+//
+// The subscriber is removed and the channel is closed when the context is done.
+// The events are sent to the channel the same way as with Subscribe, so it
+// must be read until closed.
+//
+// SubscribeContext panics if an event name is unknown with WithStrictEventNames.
+func (m *Monitor) SubscribeContext(ctx context.Context, events ...string) <-chan Event {
+	return m.subscribeContext(ctx, DeliveryBlocking, events...)
+}
+
+// subscribeContext adds the subscriber like SubscribeContext with the given delivery mode.
+func (m *Monitor) subscribeContext(ctx context.Context, mode DeliveryMode, events ...string) <-chan Event {
+	const bufferSize = 64
+
+	if err := m.checkEventNames(events); err != nil {
+		//nolint:forbidigo // the same as Subscribe
+		panic(err)
+	}
+
+	ch := make(chan Event, bufferSize)
+	sub := newSubscriber(ch, events...)
+	sub.drop = mode == DeliveryDrop
+
+	m.addSubscriber(sub)
+	context.AfterFunc(ctx, func() {
+		m.removeSubscriber(func(s *subscriber) bool { return s == sub })
+		sub.Close()
+	})
+
+	return ch
+}
+
+// Events runs the monitor and returns the iterator over the received events.
+//
+// It's the single consumer alternative to the Subscribe and Run pair:
+//
+//	for e, err := range mon.Events(ctx, "CHANNEL_ANSWER") {
+//		if err != nil {
+//			return err // Run error
+//		}
+//		// process the event
+//	}
+//
+// The last pair has a nil event and the error returned by Run. The monitor
+// is stopped when the loop breaks. If no event names are provided, all events are received.
+func (m *Monitor) Events(ctx context.Context, events ...string) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		ch := m.SubscribeContext(ctx, events...)
+		errc := make(chan error, 1)
+
+		go func() { errc <- m.Run(ctx) }()
+
+		for {
+			select {
+			case e, ok := <-ch:
+				if ok && yield(e, nil) {
+					continue
+				}
+
+				cancel()
+
+				if ok { // the loop is broken
+					<-errc // wait for Run to exit
+
+					return
+				}
+
+				yield(nil, <-errc)
+
+				return
+
+			case err := <-errc:
+				cancel()
+
+				for e := range ch { // the events received before Run exits
+					if !yield(e, nil) {
+						return
+					}
+				}
+
+				yield(nil, err)
+
+				return
+			}
+		}
+	}
+}
+
+// DeliveryMode is the delivery mode of the events to a subscriber.
+type DeliveryMode int
+
+// Delivery modes.
+const (
+	// DeliveryBlocking waits until the channel is ready to receive the event.
+	// It's the default mode, which never drops the events, but the slow
+	// subscriber stalls the delivery to all others.
+	DeliveryBlocking DeliveryMode = iota
+	// DeliveryDrop drops the events if the channel is full, so the slow
+	// subscriber doesn't stall the others.
+	DeliveryDrop
+)
+
+// SubscribeWith adds a new subscriber like Subscribe with the given delivery mode:
+//
+//	mon.Subscribe(billing, "CHANNEL_HANGUP_COMPLETE").
+//		SubscribeWith(dashboard, esl.DeliveryDrop)
+//
+// SubscribeWith panics in the same cases as Subscribe.
+func (m *Monitor) SubscribeWith(send chan<- Event, mode DeliveryMode, events ...string) *Monitor {
+	if err := m.checkEventNames(events); err != nil {
+		//nolint:forbidigo // the same as for the nil channel
+		panic(err)
 	}
 
-	return false
+	sub := newSubscriber(send, events...)
+	sub.drop = mode == DeliveryDrop
+
+	m.addSubscriber(sub)
+
+	return m
+}
+
+// Unsubscribe removes all subscribers with the given send channel.
+// The channel is not closed, but the event being sent to it is abandoned,
+// so the channel doesn't have to be read after that.
+//
+// It's safe to call while the monitor is running.
+func (m *Monitor) Unsubscribe(send chan<- Event) *Monitor {
+	m.removeSubscriber(func(s *subscriber) bool { return s.Send == send })
+
+	return m
+}
+
+// subscriberIndex is the inverted index of the subscribers by the event names.
+//
+// It's rebuilt on every subscription change and never modified after that,
+// so the dispatch finds the matching subscribers without checking all of them.
+type subscriberIndex struct {
+	byName map[string][]*subscriber // the subscribers of the name, including the wildcard ones
+	all    []*subscriber            // the wildcard subscribers for other names
+}
+
+// newSubscriberIndex returns the index of the given subscribers.
+// The subscribers order is preserved for each event name.
+func newSubscriberIndex(subscribers []*subscriber) subscriberIndex {
+	index := subscriberIndex{byName: make(map[string][]*subscriber), all: nil}
+
+	for _, sub := range subscribers {
+		if len(sub.Names) == 0 {
+			index.all = append(index.all, sub)
+
+			// the wildcard subscriber handles the already indexed names too
+			for name, list := range index.byName {
+				index.byName[name] = append(list, sub)
+			}
+
+			continue
+		}
+
+		for name := range sub.Names {
+			if _, ok := index.byName[name]; !ok {
+				index.byName[name] = slices.Clone(index.all) // the previous wildcards
+			}
+
+			index.byName[name] = append(index.byName[name], sub)
+		}
+	}
+
+	return index
+}
+
+// Lookup returns the subscribers of the event name.
+// The returned slice must not be modified.
+func (i subscriberIndex) Lookup(name string) []*subscriber {
+	if list, ok := i.byName[name]; ok {
+		return list
+	}
+
+	return i.all
+}
+
+// addSubscriber adds the subscriber and updates the server subscription
+// if the monitor is running.
+func (m *Monitor) addSubscriber(sub *subscriber) {
+	m.subsMu.Lock()
+	// copy on write: the dispatch may iterate the current slice
+	m.subscribers = append(slices.Clip(m.subscribers), sub)
+	m.subsIndex = newSubscriberIndex(m.subscribers)
+	m.subsMu.Unlock()
+
+	m.autoApplySubscriptions()
+}
+
+// removeSubscriber removes the subscribers matching the condition,
+// releases their blocked sends and updates the server subscription
+// if the monitor is running.
+func (m *Monitor) removeSubscriber(match func(*subscriber) bool) {
+	var removed []*subscriber
+
+	m.subsMu.Lock()
+	// copy on write: the dispatch may iterate the current slice
+	m.subscribers = slices.DeleteFunc(slices.Clone(m.subscribers), func(sub *subscriber) bool {
+		if match(sub) {
+			removed = append(removed, sub)
+
+			return true
+		}
+
+		return false
+	})
+	m.subsIndex = newSubscriberIndex(m.subscribers)
+	m.subsMu.Unlock()
+
+	for _, sub := range removed {
+		sub.Release()
+	}
+
+	m.autoApplySubscriptions()
+}
+
+// ApplySubscriptions updates the server subscription to the current one.
+//
+// Only the changes are sent: the added events with "event" and the removed
+// ones with "nixevent" commands in one batch, so the events subscribed before
+// and after are delivered without a gap. It's called automatically
+// on the subscription changes while the monitor is running, but can be used
+// to get the server error after several changes. After MyEvents the whole
+// subscription is sent again, following "noevents".
+//
+// Returns ErrNotConnected if the monitor is not running.
+func (m *Monitor) ApplySubscriptions(ctx context.Context) error {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+
+	if !m.connected.Load() {
+		return fmt.Errorf("apply subscriptions: %w", ErrNotConnected)
+	}
+
+	cmds, plan := m.subscriptionChanges()
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	if err := m.Exec(ctx, cmds...); err != nil {
+		return fmt.Errorf("apply subscriptions: %w", err)
+	}
+
+	m.applied, m.resubscribe = plan, false
+
+	return nil
+}
+
+// autoApplySubscriptions applies the subscriptions in background
+// if the monitor is running.
+func (m *Monitor) autoApplySubscriptions() {
+	if !m.connected.Load() {
+		return // will be subscribed on connect
+	}
+
+	go func() {
+		if err := m.ApplySubscriptions(context.Background()); err != nil {
+			m.log.Warn("esl: subscriptions", slog.String("error", err.Error()))
+		}
+	}()
+}
+
+// getSubscribers returns the current subscribers.
+// The returned slice must not be modified.
+func (m *Monitor) getSubscribers() []*subscriber {
+	m.subsMu.RLock()
+	defer m.subsMu.RUnlock()
+
+	return m.subscribers
+}
+
+// lookupSubscribers returns the subscribers of the event name.
+// The returned slice must not be modified.
+func (m *Monitor) lookupSubscribers(name string) []*subscriber {
+	m.subsMu.RLock()
+	defer m.subsMu.RUnlock()
+
+	return m.subsIndex.Lookup(name)
 }
@@ -0,0 +1,150 @@
+package esl
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSubscriberIndex(t *testing.T) {
+	ch := make(chan Event)
+	create := newSubscriber(ch, "CHANNEL_CREATE")
+	all := newSubscriber(ch)
+	both := newSubscriber(ch, "CHANNEL_CREATE", "CHANNEL_ANSWER")
+
+	index := newSubscriberIndex([]*subscriber{create, all, both})
+
+	tests := []struct {
+		name string
+		want []*subscriber
+	}{
+		{"CHANNEL_CREATE", []*subscriber{create, all, both}},
+		{"CHANNEL_ANSWER", []*subscriber{all, both}},
+		{"HEARTBEAT", []*subscriber{all}},
+	}
+
+	for _, tt := range tests {
+		got := index.Lookup(tt.name)
+		if len(got) != len(tt.want) {
+			t.Fatalf("Lookup(%q) = %d subscribers, want %d", tt.name, len(got), len(tt.want))
+		}
+
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("Lookup(%q)[%d] is the wrong subscriber", tt.name, i)
+			}
+		}
+	}
+}
+
+// BenchmarkDispatchSubscribers compares the linear check of all subscribers
+// with the index lookup for 100 subscribers of the different events.
+func TestUnsubscribeReleases(t *testing.T) {
+	ch := make(chan Event) // never read
+	monitor := New("localhost", "").Subscribe(ch, "HEARTBEAT")
+
+	errc := make(chan error, 1)
+	go func() { errc <- monitor.dispatch(Event{eventNameKey: "HEARTBEAT"}, nil) }()
+
+	time.Sleep(10 * time.Millisecond) // the send is blocked
+	monitor.Unsubscribe(ch)
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the dispatch is blocked after Unsubscribe")
+	}
+}
+
+func TestSubscribeWithDrop(t *testing.T) {
+	ch := make(chan Event) // never read
+	monitor := New("localhost", "").SubscribeWith(ch, DeliveryDrop, "HEARTBEAT")
+
+	errc := make(chan error, 1)
+	go func() { errc <- monitor.dispatch(Event{eventNameKey: "HEARTBEAT"}, nil) }()
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the dispatch is blocked by the dropping subscriber")
+	}
+}
+
+func BenchmarkDispatchSubscribers(b *testing.B) {
+	const (
+		subscribersCount = 100
+		eventsCount      = 10000
+	)
+
+	var (
+		subscribers = make([]*subscriber, 0, subscribersCount)
+		channels    = make([]chan Event, 0, subscribersCount)
+	)
+
+	for i := range subscribersCount {
+		ch := make(chan Event, eventsCount)
+		channels = append(channels, ch)
+		subscribers = append(subscribers, newSubscriber(ch, fmt.Sprintf("EVENT_%d", i)))
+	}
+
+	events := make([]Event, eventsCount)
+	for i := range events {
+		events[i] = Event{eventNameKey: fmt.Sprintf("EVENT_%d", i%subscribersCount)}
+	}
+
+	// drain empties the subscriber channels between the iterations
+	drain := func() {
+		for _, ch := range channels {
+			for range len(ch) {
+				<-ch
+			}
+		}
+	}
+
+	b.Run("linear", func(b *testing.B) {
+		for range b.N {
+			for _, event := range events {
+				for _, sub := range subscribers {
+					sub.Handle(event, nil)
+				}
+			}
+
+			b.StopTimer()
+			drain()
+			b.StartTimer()
+		}
+	})
+
+	b.Run("index", func(b *testing.B) {
+		index := newSubscriberIndex(subscribers)
+
+		for range b.N {
+			for _, event := range events {
+				for _, sub := range index.Lookup(event.Name()) {
+					sub.Handle(event, nil)
+				}
+			}
+
+			b.StopTimer()
+			drain()
+			b.StartTimer()
+		}
+	})
+}
+
+func TestSubscribeNilChannel(t *testing.T) {
+	defer func() {
+		if err, _ := recover().(error); !errors.Is(err, ErrNilChannel) {
+			t.Errorf("panic = %v, want %v", err, ErrNilChannel)
+		}
+	}()
+
+	New("localhost", "").Subscribe(nil, "CHANNEL_CREATE")
+}
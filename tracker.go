@@ -0,0 +1,91 @@
+package esl
+
+import "sync"
+
+// ChannelTracker keeps the last event of every channel by the Unique-ID header
+// and returns the changes of the channel headers on update.
+//
+// It's a building block for the channel state dashboards. The channel is
+// removed on the CHANNEL_DESTROY event. It's safe for concurrent use.
+type ChannelTracker struct {
+	mu       sync.Mutex
+	channels map[string]Event // the last event by the channel UUID
+}
+
+// NewChannelTracker returns a new empty ChannelTracker.
+func NewChannelTracker() *ChannelTracker {
+	return &ChannelTracker{
+		mu:       sync.Mutex{},
+		channels: make(map[string]Event),
+	}
+}
+
+// Update stores the event as the last one of its channel and returns
+// the changed headers with the previous and the new values, and true if
+// the channel wasn't tracked before. A missing header has the empty value.
+// The CHANNEL_DESTROY event removes the channel and is never new.
+//
+// All headers are compared, including the event metadata, like Event-Name
+// and Event-Sequence. The events without the Unique-ID header are ignored.
+func (t *ChannelTracker) Update(e Event) (changed map[string][2]string, isNew bool) { //nolint:nonamedreturns
+	uuid := e.Get("Unique-ID")
+	if uuid == "" {
+		return nil, false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	last, ok := t.channels[uuid]
+	if e.Name() == "CHANNEL_DESTROY" {
+		delete(t.channels, uuid)
+
+		return diffEvents(last, e), false // never new, as it's not tracked after
+	}
+
+	t.channels[uuid] = e
+
+	return diffEvents(last, e), !ok
+}
+
+// Get returns the last event of the channel, or nil if it's not tracked.
+func (t *ChannelTracker) Get(uuid string) Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.channels[uuid]
+}
+
+// Len returns the number of the tracked channels.
+func (t *ChannelTracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.channels)
+}
+
+// diffEvents returns the headers with the different values in the events
+// as the pairs of the old and the new decoded values.
+// The parser markers are not the headers and are skipped.
+func diffEvents(prev, next Event) map[string][2]string {
+	changed := make(map[string][2]string)
+
+	for key := range next {
+		if isMarkerKey(key) {
+			continue
+		}
+
+		prevValue, value := prev.Get(key), next.Get(key)
+		if _, ok := prev[key]; !ok || prevValue != value {
+			changed[key] = [2]string{prevValue, value}
+		}
+	}
+
+	for key := range prev {
+		if _, ok := next[key]; !ok && !isMarkerKey(key) {
+			changed[key] = [2]string{prev.Get(key), ""}
+		}
+	}
+
+	return changed
+}
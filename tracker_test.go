@@ -0,0 +1,69 @@
+package esl
+
+import "testing"
+
+func TestChannelTracker(t *testing.T) {
+	tracker := NewChannelTracker()
+
+	changed, isNew := tracker.Update(Event{
+		eventNameKey: "CHANNEL_CREATE", "Unique-ID": "a", "Channel-State": "CS_INIT",
+	})
+	if !isNew || len(changed) != 3 {
+		t.Errorf("unexpected create update: %v, %v", changed, isNew)
+	}
+
+	changed, isNew = tracker.Update(Event{
+		eventNameKey: "CHANNEL_STATE", "Unique-ID": "a", "Channel-State": "CS_ROUTING",
+	})
+	if isNew || len(changed) != 2 ||
+		changed["Channel-State"] != [2]string{"CS_INIT", "CS_ROUTING"} {
+		t.Errorf("unexpected state update: %v, %v", changed, isNew)
+	}
+
+	if tracker.Len() != 1 {
+		t.Errorf("expected one channel, got %d", tracker.Len())
+	}
+
+	tracker.Update(Event{eventNameKey: "CHANNEL_DESTROY", "Unique-ID": "a"})
+
+	if tracker.Get("a") != nil {
+		t.Error("the destroyed channel is still tracked")
+	}
+
+	// the channel created before the tracker is not new on destroy
+	if _, isNew := tracker.Update(Event{eventNameKey: "CHANNEL_DESTROY", "Unique-ID": "b"}); isNew {
+		t.Error("the destroyed untracked channel is new")
+	}
+
+	if tracker.Len() != 0 {
+		t.Errorf("expected no channels, got %d", tracker.Len())
+	}
+
+	if changed, _ := tracker.Update(Event{eventNameKey: "HEARTBEAT"}); changed != nil {
+		t.Errorf("the event without channel is tracked: %v", changed)
+	}
+}
+
+func TestChannelTrackerLazy(t *testing.T) {
+	parser := eventParser{lazy: true} //nolint:exhaustruct
+	tracker := NewChannelTracker()
+
+	for i, body := range []string{
+		"Event-Name: CHANNEL_CREATE\nUnique-ID: a\n\n",
+		"Event-Name: CHANNEL_CREATE\nUnique-ID: a\nCaller-Caller-ID-Name: John%20Doe\n\n",
+	} {
+		event, err := parser.Parse(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		changed, _ := tracker.Update(event)
+		if _, ok := changed[encodedKey]; ok {
+			t.Errorf("the parser marker is reported as changed: %v", changed)
+		}
+
+		if got := changed["Caller-Caller-ID-Name"]; i == 1 && got[1] != "John Doe" {
+			t.Errorf("the changed value is not decoded: %q", got[1])
+		}
+	}
+}
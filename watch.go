@@ -0,0 +1,128 @@
+package esl
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// watcher waits for the single event matching the condition.
+type watcher struct {
+	name  string // event name to subscribe
+	match func(Event) bool
+	ch    chan Event // buffered
+}
+
+// watchers is the set of the active event watchers.
+type watchers struct {
+	mu   sync.Mutex
+	list []*watcher
+}
+
+// Add registers a new watcher for the event with the given name
+// matching the condition.
+func (w *watchers) Add(name string, match func(Event) bool) *watcher {
+	watcher := &watcher{name: name, match: match, ch: make(chan Event, 1)}
+
+	w.mu.Lock()
+	w.list = append(w.list, watcher)
+	w.mu.Unlock()
+
+	return watcher
+}
+
+// Remove unregisters the watcher.
+func (w *watchers) Remove(watcher *watcher) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i, item := range w.list {
+		if item == watcher {
+			w.list = append(w.list[:i], w.list[i+1:]...)
+
+			return
+		}
+	}
+}
+
+// Names returns the set of the event names waited by the watchers.
+func (w *watchers) Names() map[string]struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.list) == 0 {
+		return nil
+	}
+
+	names := make(map[string]struct{}, len(w.list))
+	for _, watcher := range w.list {
+		names[watcher.name] = struct{}{}
+	}
+
+	return names
+}
+
+// Has returns true if any watcher waits for the event with the given name.
+func (w *watchers) Has(name string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return slices.ContainsFunc(w.list, func(watcher *watcher) bool {
+		return watcher.name == name
+	})
+}
+
+// Handle sends the event to the matching watchers and unregisters them.
+func (w *watchers) Handle(e Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	list := w.list[:0]
+
+	for _, watcher := range w.list {
+		if watcher.match(e) {
+			watcher.ch <- e // buffered and used once
+
+			continue
+		}
+
+		list = append(list, watcher)
+	}
+
+	clear(w.list[len(list):]) // release removed watchers
+	w.list = list
+}
+
+// WaitHangup waits for the CHANNEL_HANGUP_COMPLETE event of the channel
+// with the given UUID and returns it.
+//
+// The CHANNEL_HANGUP_COMPLETE events are added to the server subscription,
+// but delivered only to the subscribers that subscribed to them. Without
+// such subscribers, they are also skipped by the OnEvent handlers, the event
+// log, RecentEvents, EventCounts and SeenSequence.
+//
+// Returns ErrNotConnected if the monitor is not running or the context error.
+func (m *Monitor) WaitHangup(ctx context.Context, uuid string) (Event, error) {
+	const eventName = "CHANNEL_HANGUP_COMPLETE"
+
+	watcher := m.watchers.Add(eventName, func(e Event) bool {
+		return e.Name() == eventName && e.Get("Unique-ID") == uuid
+	})
+	defer func() {
+		m.watchers.Remove(watcher)
+		m.autoApplySubscriptions() // unsubscribe if not used anymore
+	}()
+
+	// the watched event is added to the server subscription
+	if err := m.ApplySubscriptions(ctx); err != nil {
+		return nil, fmt.Errorf("subscribe hangup: %w", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("wait hangup: %w", context.Cause(ctx))
+	case e := <-watcher.ch:
+		return e, nil
+	}
+}